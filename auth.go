@@ -0,0 +1,231 @@
+package handel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"sync"
+)
+
+// envelopeDomain is prepended to the packets signed by the authenticating
+// Network, so these signatures can't be mistaken for the signature of a
+// message.
+var envelopeDomain = []byte("handel packet envelope")
+
+// envelopeCacheSize is the number of envelopes the authenticating Network
+// keeps, so a packet sent to several peers is only signed once.
+const envelopeCacheSize = 64
+
+// authNetwork is a Network signing the packets it sends out and dropping the
+// incoming packets that are not signed by their origin.
+type authNetwork struct {
+	Network
+	reg    Registry
+	scheme SignatureScheme
+
+	sync.Mutex
+	// envelopes of the packets recently sent, by envelope message
+	envelopes map[string][]byte
+}
+
+// NewAuthNetwork returns a Network authenticating the packets exchanged over
+// the given Network. Each packet sent out is signed with the secret key of the
+// scheme, the signature being stored in the packet's Envelope. Incoming
+// packets are only dispatched to the listeners if their Envelope is a valid
+// signature from the Identity at their Origin in the registry. It prevents an
+// attacker from impersonating honest Handel nodes, for example to get them
+// blacklisted.
+//
+// Verifying an envelope costs a full signature verification, i.e. two
+// pairings with the BLS schemes, for every incoming packet. It runs in the
+// goroutine of the underlying Network, before the packet reaches the scoring
+// queue of Handel, so it caps the rate of packets a node can accept. When the
+// peers can share keys beforehand, NewMACNetwork is much cheaper. Envelopes
+// don't depend on the destination: a packet sent to several peers is signed
+// once.
+//
+// The returned Network must wrap the raw transport, and be given to the
+// SessionManager rather than wrapping the Network of a session: the envelope
+// covers the Session of the packet, which the Network of a session only sets
+// when sending.
+func NewAuthNetwork(n Network, reg Registry, s SignatureScheme) Network {
+	return &authNetwork{
+		Network:   n,
+		reg:       reg,
+		scheme:    s,
+		envelopes: make(map[string][]byte),
+	}
+}
+
+// Send signs the packet and sends it over the underlying Network. The given
+// packet is not modified.
+func (a *authNetwork) Send(id Identity, p *Packet) error {
+	signed := *p
+	msg, err := envelopeMessage(&signed)
+	if err != nil {
+		return err
+	}
+	if signed.Envelope, err = a.envelope(msg); err != nil {
+		return err
+	}
+	return a.Network.Send(id, &signed)
+}
+
+// envelope returns the signature of the envelope message, from the cache if
+// the same message has been signed recently.
+func (a *authNetwork) envelope(msg []byte) ([]byte, error) {
+	a.Lock()
+	env, ok := a.envelopes[string(msg)]
+	a.Unlock()
+	if ok {
+		return env, nil
+	}
+	sig, err := a.scheme.Sign(msg, nil)
+	if err != nil {
+		return nil, err
+	}
+	if env, err = sig.MarshalBinary(); err != nil {
+		return nil, err
+	}
+	a.Lock()
+	if len(a.envelopes) >= envelopeCacheSize {
+		a.envelopes = make(map[string][]byte)
+	}
+	a.envelopes[string(msg)] = env
+	a.Unlock()
+	return env, nil
+}
+
+// RegisterListener registers the listener to the underlying Network, such
+// that it only receives the authenticated packets.
+func (a *authNetwork) RegisterListener(l Listener) {
+	a.Network.RegisterListener(&authListener{a, l})
+}
+
+// authListener verifies the envelopes of the incoming packets before
+// dispatching them to the actual listener.
+type authListener struct {
+	*authNetwork
+	l Listener
+}
+
+// NewPacket returns an error and drops the packet if its envelope is not a
+// valid signature from its origin.
+func (a *authListener) NewPacket(p *Packet) error {
+	if len(p.Envelope) == 0 {
+		return errors.New("handel: packet without envelope")
+	}
+	id, ok := a.reg.Identity(int(p.Origin))
+	if !ok {
+		return errors.New("handel: packet's origin out of range")
+	}
+	sig := a.scheme.Signature()
+	if err := sig.UnmarshalBinary(p.Envelope); err != nil {
+		return err
+	}
+	msg, err := envelopeMessage(p)
+	if err != nil {
+		return err
+	}
+	if err := id.PublicKey().VerifySignature(msg, sig); err != nil {
+		return errors.New("handel: packet's envelope invalid: " + err.Error())
+	}
+	p.authenticated = true
+	return a.l.NewPacket(p)
+}
+
+// envelopeMessage returns the message signed in the envelope of the packet:
+// the domain followed by the packet marshalled without its envelope.
+func envelopeMessage(p *Packet) ([]byte, error) {
+	unsigned := *p
+	unsigned.Envelope = nil
+	buff, err := unsigned.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, envelopeDomain...), buff...), nil
+}
+
+// macNetwork is a Network authenticating the packets it exchanges with a
+// HMAC-SHA256 keyed with the key shared with each peer.
+type macNetwork struct {
+	Network
+	keys map[int][]byte
+}
+
+// NewMACNetwork returns a Network authenticating the packets exchanged over
+// the given Network, like NewAuthNetwork does, with a HMAC-SHA256 instead of a
+// signature. The keys map the ID of each peer to the secret key this node
+// shares with it; the map must not be modified afterwards. Each packet sent
+// out carries in its Envelope the MAC computed with the key of its
+// destination, and incoming packets are only dispatched to the listeners if
+// their Envelope is the MAC computed with the key of their Origin. Packets to
+// or from a peer without a key are rejected. As NewAuthNetwork, it must wrap
+// the raw transport.
+func NewMACNetwork(n Network, keys map[int][]byte) Network {
+	return &macNetwork{
+		Network: n,
+		keys:    keys,
+	}
+}
+
+// Send computes the MAC of the packet and sends it over the underlying
+// Network. The given packet is not modified.
+func (m *macNetwork) Send(id Identity, p *Packet) error {
+	key, ok := m.keys[id.ID()]
+	if !ok {
+		return errors.New("handel: no MAC key for the packet's destination")
+	}
+	authenticated := *p
+	mac, err := packetMAC(key, &authenticated)
+	if err != nil {
+		return err
+	}
+	authenticated.Envelope = mac
+	return m.Network.Send(id, &authenticated)
+}
+
+// RegisterListener registers the listener to the underlying Network, such
+// that it only receives the authenticated packets.
+func (m *macNetwork) RegisterListener(l Listener) {
+	m.Network.RegisterListener(&macListener{m, l})
+}
+
+// macListener verifies the MACs of the incoming packets before dispatching
+// them to the actual listener.
+type macListener struct {
+	*macNetwork
+	l Listener
+}
+
+// NewPacket returns an error and drops the packet if its envelope is not the
+// MAC computed with the key of its origin.
+func (m *macListener) NewPacket(p *Packet) error {
+	if len(p.Envelope) == 0 {
+		return errors.New("handel: packet without envelope")
+	}
+	key, ok := m.keys[int(p.Origin)]
+	if !ok {
+		return errors.New("handel: no MAC key for the packet's origin")
+	}
+	mac, err := packetMAC(key, p)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, p.Envelope) {
+		return errors.New("handel: packet's envelope invalid")
+	}
+	p.authenticated = true
+	return m.l.NewPacket(p)
+}
+
+// packetMAC returns the HMAC-SHA256 of the envelope message of the packet.
+func packetMAC(key []byte, p *Packet) ([]byte, error) {
+	msg, err := envelopeMessage(p)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
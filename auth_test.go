@@ -0,0 +1,157 @@
+package handel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// macKey is a key whose signatures depend on the key and the message, so
+// signatures of different keys can be told apart.
+type macKey struct {
+	id int
+}
+
+func (m *macKey) String() string              { return "mac key " + strconv.Itoa(m.id) }
+func (m *macKey) PublicKey() PublicKey        { return m }
+func (m *macKey) Combine(PublicKey) PublicKey { return m }
+func (m *macKey) Negate() PublicKey           { return m }
+func (m *macKey) Signature() Signature        { return new(macSig) }
+func (m *macKey) ID() byte                    { return 0xfe }
+func (m *macKey) Sign(msg []byte, r io.Reader) (Signature, error) {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint32(m.id))
+	h.Write(msg)
+	return &macSig{h.Sum(nil)}, nil
+}
+func (m *macKey) VerifySignature(msg []byte, s Signature) error {
+	expected, _ := m.Sign(msg, nil)
+	if !bytes.Equal(expected.(*macSig).mac, s.(*macSig).mac) {
+		return errors.New("invalid mac")
+	}
+	return nil
+}
+
+type macSig struct {
+	mac []byte
+}
+
+func (m *macSig) MarshalBinary() ([]byte, error)    { return m.mac, nil }
+func (m *macSig) UnmarshalBinary(buff []byte) error { m.mac = buff; return nil }
+func (m *macSig) Combine(Signature) Signature       { return m }
+
+func TestAuthNetwork(t *testing.T) {
+	n := 3
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(i, "auth-"+strconv.Itoa(i), &macKey{i})
+	}
+	reg := NewArrayRegistry(ids)
+	// key shared by nodes i and j
+	key := func(i, j int) []byte {
+		if i > j {
+			i, j = j, i
+		}
+		return []byte("key " + strconv.Itoa(i) + "-" + strconv.Itoa(j))
+	}
+	var tests = []struct {
+		name string
+		new  func(n Network, i int) Network
+		// listener of the wrapped network verifying the envelopes
+		listener func(n Network, l Listener) Listener
+	}{
+		{
+			"signature",
+			func(n Network, i int) Network { return NewAuthNetwork(n, reg, &macKey{i}) },
+			func(n Network, l Listener) Listener { return &authListener{n.(*authNetwork), l} },
+		},
+		{
+			"mac",
+			func(n Network, i int) Network {
+				keys := make(map[int][]byte)
+				for j := 0; j < len(ids); j++ {
+					if j != i {
+						keys[j] = key(i, j)
+					}
+				}
+				return NewMACNetwork(n, keys)
+			},
+			func(n Network, l Listener) Listener { return &macListener{n.(*macNetwork), l} },
+		},
+	}
+
+	for _, test := range tests {
+		tn := newTestNetwork()
+		nets := make([]Network, n)
+		for i := range nets {
+			nets[i] = test.new(tn.node(ids[i].Address()), i)
+		}
+		l := new(packetRecorder)
+		nets[1].RegisterListener(l)
+
+		p := &Packet{Origin: 0, Level: 1, MultiSig: []byte("multisig")}
+		require.NoError(t, nets[0].Send(ids[1], p), test.name)
+		require.Nil(t, p.Envelope, test.name)
+		require.Eventually(t, func() bool {
+			return len(l.received()) == 1
+		}, time.Second, 10*time.Millisecond, test.name)
+		received := l.received()[0]
+		require.Equal(t, p.MultiSig, received.MultiSig, test.name)
+		require.NotEmpty(t, received.Envelope, test.name)
+		require.True(t, received.authenticated, test.name)
+
+		// node 2 can't impersonate node 0
+		require.NoError(t, nets[2].Send(ids[1], p), test.name)
+		time.Sleep(20 * time.Millisecond)
+		require.Len(t, l.received(), 1, test.name)
+
+		auth := test.listener(nets[1], l)
+		require.NoError(t, auth.NewPacket(received), test.name)
+		tampered := *received
+		tampered.Level = 2
+		require.Error(t, auth.NewPacket(&tampered), test.name)
+		tampered = *received
+		tampered.Envelope = nil
+		require.Error(t, auth.NewPacket(&tampered), test.name)
+		tampered = *received
+		tampered.Origin = 10
+		require.Error(t, auth.NewPacket(&tampered), test.name)
+	}
+
+	// no key shared with itself
+	mac := tests[1].new(newTestNetwork().node("mac"), 0)
+	require.Error(t, mac.Send(ids[0], &Packet{Origin: 0, Level: 1}))
+}
+
+// countingKey counts the signatures it produces.
+type countingKey struct {
+	*macKey
+	signs int
+}
+
+func (c *countingKey) Sign(msg []byte, r io.Reader) (Signature, error) {
+	c.signs++
+	return c.macKey.Sign(msg, r)
+}
+
+func TestAuthNetworkEnvelopeCache(t *testing.T) {
+	tn := newTestNetwork()
+	key := &countingKey{macKey: &macKey{0}}
+	net := NewAuthNetwork(tn.node("auth-0"), fakeRegistry(4), key)
+	p := &Packet{Origin: 0, Level: 1, MultiSig: []byte("multisig")}
+	for i := 1; i < 4; i++ {
+		require.NoError(t, net.Send(&fakeIdentity{i}, p))
+	}
+	require.Equal(t, 1, key.signs)
+
+	p.Level = 2
+	require.NoError(t, net.Send(&fakeIdentity{1}, p))
+	require.Equal(t, 2, key.signs)
+}
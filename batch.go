@@ -0,0 +1,59 @@
+package handel
+
+// VerifyItem is a signature to verify against a message and a public key, as
+// part of a batch.
+type VerifyItem struct {
+	Msg       []byte
+	PublicKey PublicKey
+	Signature Signature
+}
+
+// BatchVerifier is optionally implemented by the SignatureSchemes able to
+// verify many signatures at once faster than one by one, for example by
+// verifying a random linear combination of them. When the signature scheme of
+// a Handel node implements it, the verification workers verify up to
+// Config.BatchSize multi-signatures at once.
+type BatchVerifier interface {
+	// VerifyBatch returns nil if all the signatures of the batch are valid.
+	// It returns an error if any of them is invalid, without telling which
+	// one.
+	VerifyBatch(items []VerifyItem) error
+}
+
+// BatchVerify verifies all the items with the BatchVerifier. It returns nil if
+// all the signatures are valid. Otherwise, it locates the invalid signatures
+// by bisecting the failed batches, and returns the verification error of each
+// item, nil for the valid ones.
+func BatchVerify(b BatchVerifier, items []VerifyItem) []error {
+	if len(items) == 0 || b.VerifyBatch(items) == nil {
+		return nil
+	}
+	errs := make([]error, len(items))
+	if len(items) == 1 {
+		errs[0] = verifyItem(items[0])
+		return errs
+	}
+	mid := len(items) / 2
+	bisect(b, items[:mid], errs[:mid])
+	bisect(b, items[mid:], errs[mid:])
+	return errs
+}
+
+// bisect verifies the batch and, if it fails, each of its halves, until the
+// invalid signatures are found. The errors of the items are written to errs.
+func bisect(b BatchVerifier, items []VerifyItem, errs []error) {
+	if len(items) == 1 {
+		errs[0] = verifyItem(items[0])
+		return
+	}
+	if b.VerifyBatch(items) == nil {
+		return
+	}
+	mid := len(items) / 2
+	bisect(b, items[:mid], errs[:mid])
+	bisect(b, items[mid:], errs[mid:])
+}
+
+func verifyItem(item VerifyItem) error {
+	return item.PublicKey.VerifySignature(item.Msg, item.Signature)
+}
@@ -0,0 +1,116 @@
+package handel
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// batchScheme is a fakeScheme verifying batches by verifying each signature,
+// and counting the batches it verifies.
+type batchScheme struct {
+	fakeScheme
+	sync.Mutex
+	batches int
+}
+
+func (b *batchScheme) VerifyBatch(items []VerifyItem) error {
+	b.Lock()
+	b.batches++
+	b.Unlock()
+	for _, item := range items {
+		if err := verifyItem(item); err != nil {
+			return errors.New("invalid batch")
+		}
+	}
+	return nil
+}
+
+func (b *batchScheme) count() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.batches
+}
+
+func TestBatchVerify(t *testing.T) {
+	items := make([]VerifyItem, 7)
+	for i := range items {
+		items[i] = VerifyItem{Msg: msg, PublicKey: new(fakePublic), Signature: new(fakeSig)}
+	}
+	b := new(batchScheme)
+	require.Nil(t, BatchVerify(b, items))
+	require.Equal(t, 1, b.count())
+	require.Nil(t, BatchVerify(b, nil))
+
+	items[2].Signature = &fakeSig{bad: true}
+	items[6].Signature = &fakeSig{bad: true}
+	errs := BatchVerify(b, items)
+	require.Len(t, errs, len(items))
+	for i, err := range errs {
+		if i == 2 || i == 6 {
+			require.Error(t, err, "item %d", i)
+		} else {
+			require.NoError(t, err, "item %d", i)
+		}
+	}
+
+	errs = BatchVerify(b, items[2:3])
+	require.Len(t, errs, 1)
+	require.Error(t, errs[0])
+}
+
+func TestHandelBatchVerification(t *testing.T) {
+	n := 16
+	reg := fakeRegistry(n)
+	net := newTestNetwork()
+	schemes := make([]*batchScheme, n)
+	handels := make([]*Handel, n)
+	for i := range handels {
+		id, _ := reg.Identity(i)
+		schemes[i] = new(batchScheme)
+		h, err := NewHandel(net.node(id.Address()), reg, id, schemes[i], msg, testConfig())
+		require.NoError(t, err)
+		handels[i] = h
+	}
+	for _, h := range handels {
+		h.Start()
+		defer h.Stop()
+	}
+	for i, h := range handels {
+		require.Eventually(t, func() bool {
+			return h.Aggregate().Cardinality() == n
+		}, 5*time.Second, 10*time.Millisecond, "node %d", i)
+	}
+}
+
+func TestHandelBatchBlacklist(t *testing.T) {
+	reg := fakeRegistry(4)
+	id, _ := reg.Identity(0)
+	conf := testConfig()
+	conf.LevelTimeout = time.Hour
+	scheme := new(batchScheme)
+	h, err := NewHandel(newTestNetwork().node(id.Address()), reg, id, scheme, msg, conf)
+	require.NoError(t, err)
+	defer h.Stop()
+
+	bad := newTestMultiSig(2, 0)
+	bad.Signature = &fakeSig{bad: true}
+	// both multi-signatures of the level 2 are verified in the same batch
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 2, 2, bad)))
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 3, 2, newTestMultiSig(2, 0, 1))))
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 1, 1, newTestMultiSig(1, 0))))
+
+	h.Start()
+	require.Eventually(t, func() bool {
+		return h.Aggregate().Cardinality() == 4
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return h.blacklist[2]
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, scheme.count() > 0)
+}
@@ -3,6 +3,7 @@ package handel
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 
 	"github.com/willf/bitset"
 )
@@ -28,7 +29,8 @@ type BitSet interface {
 	Slice(from, to int) BitSet
 	// MarshalBinary returns the binary representation of the BitSet.
 	MarshalBinary() ([]byte, error)
-	// UnmarshalBinary fills the bitset from the given buffer.
+	// UnmarshalBinary fills the bitset from the given buffer. It returns an
+	// error if the buffer holds bits set beyond the bitset's length.
 	UnmarshalBinary([]byte) error
 }
 
@@ -82,17 +84,17 @@ func (w *WilffBitSet) Combine(b2 BitSet) BitSet {
 	for i := 0; i < w2.l; i++ {
 		w3.Set(i+w.l, w2.Get(i))
 	}
-	return w
+	return w3
 }
 
 func (w *WilffBitSet) Slice(from, to int) BitSet {
-	if !w.inBound(from) || to < from || to > w.l {
+	if from < 0 || to < from || to > w.l {
 		return w
 	}
 	newLength := to - from
 	w2 := NewWilffBitset(newLength)
 	for i := 0; i < newLength; i++ {
-		w2.Set(i, w2.Get(i+from))
+		w2.Set(i, w.Get(i+from))
 	}
 	return w2
 }
@@ -124,6 +126,19 @@ func (w *WilffBitSet) UnmarshalBinary(buff []byte) error {
 		return err
 	}
 
-	w.b = new(bitset.BitSet)
-	return w.b.UnmarshalBinary(b.Bytes())
+	bs := new(bitset.BitSet)
+	if err := bs.UnmarshalBinary(b.Bytes()); err != nil {
+		return err
+	}
+	// the bitset comes from the network: bits beyond the announced length
+	// would inflate its cardinality
+	if bs.Len() != uint(length) {
+		return errors.New("handel: bitset length does not match its header")
+	}
+	if _, found := bs.NextSet(uint(length)); found {
+		return errors.New("handel: bitset has bits set beyond its length")
+	}
+	w.l = int(length)
+	w.b = bs
+	return nil
 }
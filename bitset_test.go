@@ -38,6 +38,30 @@ func TestBitSetWilff(t *testing.T) {
 	testBitSets(t, tests)
 }
 
+func TestBitSetWilffCombineSlice(t *testing.T) {
+	b1 := nb(3)
+	b1.Set(1, true)
+	b2 := nb(4)
+	b2.Set(0, true)
+	b2.Set(3, true)
+
+	b3 := b1.Combine(b2)
+	require.Equal(t, 7, b3.BitLength())
+	require.Equal(t, 3, b3.Cardinality())
+	for _, idx := range []int{1, 3, 6} {
+		require.True(t, b3.Get(idx))
+	}
+
+	b4 := b3.Slice(3, 7)
+	require.Equal(t, 4, b4.BitLength())
+	require.Equal(t, 2, b4.Cardinality())
+	require.True(t, b4.Get(0))
+	require.True(t, b4.Get(3))
+
+	require.Equal(t, 0, b3.Slice(7, 7).BitLength())
+	require.Equal(t, b3, b3.Slice(4, 2))
+}
+
 func testBitSets(t *testing.T, tests []bitsetTest) {
 	for _, tt := range tests {
 		bitset := tt.fb()
@@ -63,3 +87,29 @@ func testBitSetWilffMarshalling(t *testing.T) {
 
 	require.Equal(t, b.l, b2.l)
 }
+
+// paddedBitset returns the encoding of a bitset of the given length whose
+// last word has all its bits set, including the ones beyond the length.
+func paddedBitset(t *testing.T, length int) []byte {
+	b := nb(length)
+	b.Set(0, true)
+	buff, err := b.MarshalBinary()
+	require.NoError(t, err)
+	for i := len(buff) - 8; i < len(buff); i++ {
+		buff[i] = 0xff
+	}
+	return buff
+}
+
+func TestBitSetWilffUnmarshalPadded(t *testing.T) {
+	b := new(WilffBitSet)
+	require.Error(t, b.UnmarshalBinary(paddedBitset(t, 2)))
+
+	// header announcing a shorter length than the encoded bitset
+	long := nb(64)
+	long.Set(63, true)
+	buff, err := long.MarshalBinary()
+	require.NoError(t, err)
+	buff[0], buff[1] = 0, 2
+	require.Error(t, b.UnmarshalBinary(buff))
+}
@@ -0,0 +1,331 @@
+// Package bls12381 allows to use Handel with the BLS signature scheme over the
+// BLS12-381 curve. It implements the relevant Handel interfaces: PublicKey,
+// SecretKey, Signature and SignatureScheme. Public keys are points of G1 and
+// signatures points of G2, both encoded in the compressed form of the zcash
+// serialization, as in Ethereum 2 and Filecoin. The BLS12-381 implementation
+// comes from the kilic/bls12-381 package.
+package bls12381
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ConsenSys/handel"
+	bls12 "github.com/kilic/bls12-381"
+)
+
+// Domain is the domain separation tag used to hash the messages to G2. It is
+// the one of the proof of possession scheme of the IETF BLS signatures draft,
+// used by Ethereum 2.
+var Domain = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+
+// PossessionDomain is the domain separation tag used to hash the public keys
+// to G2 in the proofs of possession. It is the one of the proof of possession
+// scheme of the IETF BLS signatures draft.
+var PossessionDomain = []byte("BLS_POP_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+
+// order is the order of the groups G1 and G2.
+var order, _ = new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+
+// SchemeID is the identifier of the BLS12-381 signature scheme in the Handel
+// packets.
+const SchemeID byte = 2
+
+func init() {
+	handel.RegisterPublicKeyDecoder(SchemeID, UnmarshalPublicKey)
+}
+
+// scheme implements the handel.SignatureScheme interface
+type scheme struct {
+	handel.SecretKey
+}
+
+// NewSignatureScheme returns a signature scheme using the provided secret key
+// for the BLS12-381 BLS multi-signatures.
+func NewSignatureScheme(s handel.SecretKey) handel.SignatureScheme {
+	return &scheme{s}
+}
+
+func (s *scheme) Signature() handel.Signature {
+	return new(sig)
+}
+
+func (s *scheme) ID() byte {
+	return SchemeID
+}
+
+// batchScalarBits is the size of the random scalars used to verify batches.
+const batchScalarBits = 128
+
+// VerifyBatch verifies the k signatures at once with k+1 pairings, by checking
+// that the random linear combination e(B1, sum r_i*S_i) == prod
+// e(X_i, r_i*H(m_i)) holds, where r_i are random scalars. An invalid
+// signature passes the check with a negligible probability. It implements the
+// handel.BatchVerifier interface.
+func (s *scheme) VerifyBatch(items []handel.VerifyItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	hashes := make(map[string]*bls12.PointG2)
+	bound := new(big.Int).Lsh(big.NewInt(1), batchScalarBits)
+	engine := bls12.NewEngine()
+	g2 := engine.G2
+	sum := g2.Zero()
+	for _, item := range items {
+		pub, ok := item.PublicKey.(*publicKey)
+		if !ok {
+			return errors.New("bls12381: invalid public key type")
+		}
+		ms, ok := item.Signature.(*sig)
+		if !ok || ms.e == nil {
+			return errors.New("bls12381: invalid signature type")
+		}
+		hm, ok := hashes[string(item.Msg)]
+		if !ok {
+			var err error
+			if hm, err = hashedMessage(item.Msg); err != nil {
+				return err
+			}
+			hashes[string(item.Msg)] = hm
+		}
+		r, err := rand.Int(rand.Reader, bound)
+		if err != nil {
+			return err
+		}
+		rhm := g2.New()
+		g2.MulScalarBig(rhm, hm, r)
+		rs := g2.New()
+		g2.MulScalarBig(rs, ms.e, r)
+		g2.Add(sum, sum, rs)
+		// the engine modifies the points given, so they are copied
+		engine.AddPair(new(bls12.PointG1).Set(pub.p), rhm)
+	}
+	engine.AddPairInv(engine.G1.One(), sum)
+	if !engine.Check() {
+		return errors.New("bls12381: batch of signatures invalid")
+	}
+	return nil
+}
+
+type publicKey struct {
+	p *bls12.PointG1
+}
+
+// UnmarshalPublicKey returns the public key from its compressed encoding, as
+// returned by its MarshalBinary method. It is registered as the
+// handel.PublicKeyDecoder of the scheme, so BLS12-381 public keys can be read
+// from roster files.
+func UnmarshalPublicKey(buff []byte) (handel.PublicKey, error) {
+	p := new(publicKey)
+	if err := p.UnmarshalBinary(buff); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *publicKey) String() string {
+	buff, _ := p.MarshalBinary()
+	return fmt.Sprintf("bls12381.G1(%x)", buff)
+}
+
+func (p *publicKey) MarshalBinary() ([]byte, error) {
+	if p.p == nil {
+		return nil, errors.New("bls12381: public key can't marshal if nil")
+	}
+	return bls12.NewG1().ToCompressed(new(bls12.PointG1).Set(p.p)), nil
+}
+
+// UnmarshalBinary reads the public key from its compressed encoding. It
+// returns an error if the point is not in the G1 subgroup or is the point at
+// infinity.
+func (p *publicKey) UnmarshalBinary(buff []byte) error {
+	g := bls12.NewG1()
+	point, err := g.FromCompressed(buff)
+	if err != nil {
+		return errors.New("bls12381: public key can't unmarshal: " + err.Error())
+	}
+	if g.IsZero(point) {
+		return errors.New("bls12381: public key is the point at infinity")
+	}
+	p.p = point
+	return nil
+}
+
+// VerifySignature checks the given BLS signature S on the message m using the
+// public key X = x*B1 by verifying that the equality e(X, H(m)) ==
+// e(B1, x*H(m)) == e(B1, S) holds where e is the pairing operation and B1 is
+// the base point of G1.
+func (p *publicKey) VerifySignature(msg []byte, s handel.Signature) error {
+	hm, err := hashedMessage(msg)
+	if err != nil {
+		return err
+	}
+	return p.verify(hm, s)
+}
+
+// VerifyPossession checks that the proof is the signature of the compressed
+// public key hashed to G2 with the PossessionDomain. It implements the
+// handel.PossessionVerifier interface.
+func (p *publicKey) VerifyPossession(proof handel.Signature) error {
+	buff, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	hp, err := bls12.NewG2().HashToCurve(buff, PossessionDomain)
+	if err != nil {
+		return err
+	}
+	if err := p.verify(hp, proof); err != nil {
+		return errors.New("bls12381: proof of possession invalid")
+	}
+	return nil
+}
+
+// verify checks that the signature is the signature of the given point of G2.
+func (p *publicKey) verify(hm *bls12.PointG2, s handel.Signature) error {
+	ms, ok := s.(*sig)
+	if !ok || ms.e == nil {
+		return errors.New("bls12381: invalid signature type")
+	}
+	engine := bls12.NewEngine()
+	// the engine modifies the points given, so they are copied
+	engine.AddPair(new(bls12.PointG1).Set(p.p), hm)
+	engine.AddPairInv(engine.G1.One(), new(bls12.PointG2).Set(ms.e))
+	if !engine.Check() {
+		return errors.New("bls12381: signature invalid")
+	}
+	return nil
+}
+
+func (p *publicKey) Combine(pp handel.PublicKey) handel.PublicKey {
+	p2 := pp.(*publicKey)
+	p3 := new(bls12.PointG1)
+	bls12.NewG1().Add(p3, p.p, p2.p)
+	return &publicKey{p3}
+}
+
+func (p *publicKey) Negate() handel.PublicKey {
+	p2 := new(bls12.PointG1)
+	bls12.NewG1().Neg(p2, p.p)
+	return &publicKey{p2}
+}
+
+type secretKey struct {
+	*publicKey
+	s *bls12.Fr
+}
+
+// NewSecretKey returns a new keypair generated from the given reader, or from
+// crypto/rand if nil.
+func NewSecretKey(reader io.Reader) (handel.SecretKey, error) {
+	if reader == nil {
+		reader = rand.Reader
+	}
+	secret := bls12.NewFr()
+	for secret.IsZero() {
+		if _, err := secret.Rand(reader); err != nil {
+			return nil, err
+		}
+	}
+	g := bls12.NewG1()
+	public := g.New()
+	g.MulScalar(public, g.One(), secret)
+	return &secretKey{
+		s:         secret,
+		publicKey: &publicKey{p: public},
+	}, nil
+}
+
+// UnmarshalSecretKey returns the keypair of the secret key encoded as 32
+// big-endian bytes, as in Ethereum 2. It returns an error if the secret key is
+// zero or not lower than the order of the groups.
+func UnmarshalSecretKey(buff []byte) (handel.SecretKey, error) {
+	if len(buff) != 32 {
+		return nil, errors.New("bls12381: secret key must be 32 bytes long")
+	}
+	x := new(big.Int).SetBytes(buff)
+	if x.Sign() == 0 || x.Cmp(order) >= 0 {
+		return nil, errors.New("bls12381: secret key out of range")
+	}
+	secret := bls12.NewFr().FromBytes(buff)
+	g := bls12.NewG1()
+	public := g.New()
+	g.MulScalar(public, g.One(), secret)
+	return &secretKey{
+		s:         secret,
+		publicKey: &publicKey{p: public},
+	}, nil
+}
+
+func (s *secretKey) PublicKey() handel.PublicKey {
+	return s.publicKey
+}
+
+// Sign creates a BLS signature S = x * H(m) on a message m using the private
+// key x. The signature S is a point of G2.
+func (s *secretKey) Sign(msg []byte, reader io.Reader) (handel.Signature, error) {
+	hm, err := hashedMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(hm), nil
+}
+
+// ProvePossession signs the compressed public key hashed to G2 with the
+// PossessionDomain. It implements the handel.PossessionProver interface.
+func (s *secretKey) ProvePossession() (handel.Signature, error) {
+	buff, err := s.publicKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	hp, err := bls12.NewG2().HashToCurve(buff, PossessionDomain)
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(hp), nil
+}
+
+// sign returns the signature of the given point of G2.
+func (s *secretKey) sign(hm *bls12.PointG2) handel.Signature {
+	g := bls12.NewG2()
+	res := g.New()
+	g.MulScalar(res, hm, s.s)
+	return &sig{res}
+}
+
+type sig struct {
+	e *bls12.PointG2
+}
+
+func (m *sig) MarshalBinary() ([]byte, error) {
+	if m.e == nil {
+		return nil, errors.New("bls12381: multisig can't marshal if nil")
+	}
+	return bls12.NewG2().ToCompressed(new(bls12.PointG2).Set(m.e)), nil
+}
+
+// UnmarshalBinary reads the signature from its compressed encoding. It returns
+// an error if the point is not in the G2 subgroup.
+func (m *sig) UnmarshalBinary(b []byte) error {
+	e, err := bls12.NewG2().FromCompressed(b)
+	if err != nil {
+		return errors.New("bls12381: multisig can't unmarshal: " + err.Error())
+	}
+	m.e = e
+	return nil
+}
+
+func (m *sig) Combine(ms handel.Signature) handel.Signature {
+	m2 := ms.(*sig)
+	res := new(bls12.PointG2)
+	bls12.NewG2().Add(res, m.e, m2.e)
+	return &sig{e: res}
+}
+
+// hashedMessage returns the message hashed to G2 with the Domain.
+func hashedMessage(msg []byte) (*bls12.PointG2, error) {
+	return bls12.NewG2().HashToCurve(msg, Domain)
+}
@@ -0,0 +1,284 @@
+package bls12381
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/ConsenSys/handel/network/local"
+	bls12 "github.com/kilic/bls12-381"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+
+	sk, err := NewSecretKey(rand.Reader)
+	require.NoError(t, err)
+
+	sig, err := sk.Sign(msg, nil)
+	require.NoError(t, err)
+
+	pk := sk.PublicKey()
+	require.NoError(t, pk.VerifySignature(msg, sig))
+	require.Error(t, pk.VerifySignature([]byte("Get Funky Tomorrow"), sig))
+}
+
+func TestCombine(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+
+	sk1, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sk2, err := NewSecretKey(nil)
+	require.NoError(t, err)
+
+	pk1 := sk1.PublicKey()
+	pk2 := sk2.PublicKey()
+	require.NotEqual(t, pk1.String(), pk2.String())
+
+	sig1, err := sk1.Sign(msg, nil)
+	require.NoError(t, err)
+	sig2, err := sk2.Sign(msg, nil)
+	require.NoError(t, err)
+
+	sig3 := sig1.Combine(sig2)
+	pk3 := pk1.Combine(pk2)
+	require.NoError(t, pk3.VerifySignature(msg, sig3))
+	require.Error(t, pk1.VerifySignature(msg, sig3))
+}
+
+func TestMarshalling(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+	sk, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	s, err := sk.Sign(msg, nil)
+	require.NoError(t, err)
+
+	buff, err := s.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, buff, 96)
+	s2 := NewSignatureScheme(sk).Signature()
+	require.NoError(t, s2.UnmarshalBinary(buff))
+
+	buff, err = sk.PublicKey().(*publicKey).MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, buff, 48)
+	pk, err := UnmarshalPublicKey(buff)
+	require.NoError(t, err)
+	require.NoError(t, pk.VerifySignature(msg, s2))
+
+	// point at infinity
+	infinity, _ := hex.DecodeString("c0" + hex.EncodeToString(make([]byte, 47)))
+	_, err = UnmarshalPublicKey(infinity)
+	require.Error(t, err)
+	_, err = UnmarshalPublicKey(buff[:47])
+	require.Error(t, err)
+	require.Error(t, new(sig).UnmarshalBinary(buff))
+}
+
+func TestHandel(t *testing.T) {
+	n := 8
+	msg := []byte("Get Funky Tonight")
+	hub := local.NewHub(new(local.Config))
+	defer hub.Stop()
+	secrets := make([]handel.SecretKey, n)
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		sk, err := NewSecretKey(nil)
+		require.NoError(t, err)
+		secrets[i] = sk
+		ids[i] = handel.NewStaticIdentity(i, "node-"+strconv.Itoa(i), sk.PublicKey())
+	}
+	reg := handel.NewArrayRegistry(ids)
+
+	handels := make([]*handel.Handel, n)
+	for i, sk := range secrets {
+		conf := &handel.Config{ContributionsThreshold: n, UpdatePeriod: 20 * time.Millisecond}
+		h, err := handel.NewHandel(hub.Network(ids[i].Address()), reg, ids[i], NewSignatureScheme(sk), msg, conf)
+		require.NoError(t, err)
+		handels[i] = h
+	}
+	for _, h := range handels {
+		h.Start()
+		defer h.Stop()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality(), "node %d", i)
+			buff, err := ms.Signature.MarshalBinary()
+			require.NoError(t, err)
+			require.Len(t, buff, 96)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("node %d did not output a multi-signature", i)
+		}
+	}
+}
+
+func TestPossession(t *testing.T) {
+	sk1, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sk2, err := NewSecretKey(nil)
+	require.NoError(t, err)
+
+	proof1, err := sk1.(handel.PossessionProver).ProvePossession()
+	require.NoError(t, err)
+	id1 := handel.NewStaticIdentity(0, "", sk1.PublicKey())
+	id2 := handel.NewStaticIdentity(1, "", sk2.PublicKey())
+	require.NoError(t, handel.VerifyPossession(id1, proof1))
+	require.Error(t, handel.VerifyPossession(id2, proof1))
+
+	// the signature of the public key as a message is not a proof
+	buff, err := sk1.PublicKey().(*publicKey).MarshalBinary()
+	require.NoError(t, err)
+	s, err := sk1.Sign(buff, nil)
+	require.NoError(t, err)
+	require.Error(t, handel.VerifyPossession(id1, s))
+}
+
+func TestVerifyBatch(t *testing.T) {
+	msgs := [][]byte{[]byte("Get Funky Tonight"), []byte("Get Funky Tomorrow")}
+	var items []handel.VerifyItem
+	var sk handel.SecretKey
+	for i := 0; i < 4; i++ {
+		var err error
+		sk, err = NewSecretKey(nil)
+		require.NoError(t, err)
+		msg := msgs[i%2]
+		s, err := sk.Sign(msg, nil)
+		require.NoError(t, err)
+		items = append(items, handel.VerifyItem{Msg: msg, PublicKey: sk.PublicKey(), Signature: s})
+	}
+	bv := NewSignatureScheme(sk).(handel.BatchVerifier)
+	require.NoError(t, bv.VerifyBatch(items))
+
+	// swapped signatures are detected
+	items[1].Signature, items[3].Signature = items[3].Signature, items[1].Signature
+	require.Error(t, bv.VerifyBatch(items))
+	errs := handel.BatchVerify(bv, items)
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	require.NoError(t, errs[2])
+	require.Error(t, errs[3])
+}
+
+func TestNegate(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+	sk1, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sk2, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sig1, err := sk1.Sign(msg, nil)
+	require.NoError(t, err)
+
+	pk := sk1.PublicKey().Combine(sk2.PublicKey()).Combine(sk2.PublicKey().Negate())
+	require.NoError(t, pk.VerifySignature(msg, sig1))
+}
+
+// Known answers of the sign and aggregate cases of the Ethereum 2 BLS tests,
+// which use the same ciphersuite, keys and encodings.
+var vectorSecrets = []string{
+	"263dbd792f5b1be47ed85f8938c0f29586af0d3ac7b977f21c278fe1462040e3",
+	"47b8192d77bf871b62e87859d653922725724a5c031afeabc60bcef5ff665138",
+	"328388aff0d4a5b7dc9205abd374e7e98f3cd9f3418edb4eafda5fb16473d216",
+}
+
+var signVectors = []struct {
+	secret int
+	public string
+	msg    string
+	sig    string
+}{
+	{
+		0,
+		"a491d1b0ecd9bb917989f0e74f0dea0422eac4a873e5e2644f368dffb9a6e20fd6e10c1b77654d067c0618f6e5a7f79a",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"b6ed936746e01f8ecf281f020953fbf1f01debd5657c4a383940b020b26507f6076334f91e2366c96e9ab279fb5158090352ea1c5b0c9274504f4f0e7053af24802e51e4568d164fe986834f41e55c8e850ce1f98458c0cfc9ab380b55285a55",
+	},
+	{
+		1,
+		"b301803f8b5ac4a1133581fc676dfedc60d891dd5fa99028805e5ea5b08d3491af75d0707adab3b70c6a6a580217bf81",
+		"5656565656565656565656565656565656565656565656565656565656565656",
+		"af1390c3c47acdb37131a51216da683c509fce0e954328a59f93aebda7e4ff974ba208d9a4a2a2389f892a9d418d618418dd7f7a6bc7aa0da999a9d3a5b815bc085e14fd001f6a1948768a3f4afefc8b8240dda329f984cb345c6363272ba4fe",
+	},
+}
+
+// aggregateVector is the aggregate of the signatures of the zero message of
+// 32 bytes by all the vectorSecrets.
+const aggregateVector = "9683b3e6701f9a4b706709577963110043af78a5b41991b998475a3d3fd62abf35ce03b33908418efc95a058494a8ae504354b9f626231f6b3f3c849dfdeaf5017c4780e2aee1850ceaf4b4d9ce70971a3d2cfcd97b7e5ecf6759f8da5f76d31"
+
+func vectorSecret(t *testing.T, i int) handel.SecretKey {
+	buff, err := hex.DecodeString(vectorSecrets[i])
+	require.NoError(t, err)
+	sk, err := UnmarshalSecretKey(buff)
+	require.NoError(t, err)
+	return sk
+}
+
+func TestSignVectors(t *testing.T) {
+	for i, v := range signVectors {
+		sk := vectorSecret(t, v.secret)
+		pub, err := sk.PublicKey().(*publicKey).MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, v.public, hex.EncodeToString(pub), "vector %d", i)
+
+		msg, err := hex.DecodeString(v.msg)
+		require.NoError(t, err)
+		sig, err := sk.Sign(msg, nil)
+		require.NoError(t, err)
+		buff, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, v.sig, hex.EncodeToString(buff), "vector %d", i)
+		require.NoError(t, sk.PublicKey().VerifySignature(msg, sig))
+	}
+
+	msg := make([]byte, 32)
+	var agg handel.Signature
+	for i := range vectorSecrets {
+		sig, err := vectorSecret(t, i).Sign(msg, nil)
+		require.NoError(t, err)
+		if agg == nil {
+			agg = sig
+		} else {
+			agg = agg.Combine(sig)
+		}
+	}
+	buff, err := agg.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, aggregateVector, hex.EncodeToString(buff))
+}
+
+func TestPossessionVector(t *testing.T) {
+	// the proof of possession of the IETF BLS signatures draft signs the
+	// compressed public key hashed to G2 with its own tag
+	sk := vectorSecret(t, 0)
+	pub, err := sk.PublicKey().(*publicKey).MarshalBinary()
+	require.NoError(t, err)
+	hp, err := bls12.NewG2().HashToCurve(pub, []byte("BLS_POP_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_"))
+	require.NoError(t, err)
+	g := bls12.NewG2()
+	expected := g.New()
+	g.MulScalar(expected, hp, sk.(*secretKey).s)
+
+	proof, err := sk.(handel.PossessionProver).ProvePossession()
+	require.NoError(t, err)
+	buff, err := proof.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, g.ToCompressed(expected), buff)
+	require.NoError(t, sk.PublicKey().(*publicKey).VerifyPossession(proof))
+}
+
+func TestUnmarshalSecretKey(t *testing.T) {
+	_, err := UnmarshalSecretKey(make([]byte, 32))
+	require.Error(t, err)
+	_, err = UnmarshalSecretKey(make([]byte, 31))
+	require.Error(t, err)
+	// the order of the groups
+	order, err := hex.DecodeString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001")
+	require.NoError(t, err)
+	_, err = UnmarshalSecretKey(order)
+	require.Error(t, err)
+}
@@ -39,6 +39,28 @@ var G2Base *bn256.G2
 // point.
 var Hash = sha256.New
 
+// Domain is the domain separation tag used to hash the messages to G1.
+var Domain = []byte("HANDEL_BN256_G1_TAI_SHA256_SIG_")
+
+// PossessionDomain is the domain separation tag used to hash the public keys to
+// G1 in the proofs of possession, so a proof of possession can't be mistaken
+// for the signature of a message.
+var PossessionDomain = []byte("HANDEL_BN256_G1_TAI_SHA256_POP_")
+
+// p is the prime of the field over which the G1 curve y^2 = x^3 + 3 is
+// defined. It is taken from the cloudflare's bn256 implementation.
+var p, _ = new(big.Int).SetString("65000549695646603732796438742359905742825358107623003571877145026864184071783", 10)
+
+// curveB is the constant of the G1 curve equation.
+var curveB = big.NewInt(3)
+
+// sqrtExp is the exponent giving a square root modulo p, since p = 3 mod 4.
+var sqrtExp = new(big.Int).Rsh(new(big.Int).Add(p, big.NewInt(1)), 2)
+
+// maxTries is the number of candidates tried by HashToG1 before giving up.
+// Each candidate maps to the curve with probability 1/2.
+const maxTries = 256
+
 func init() {
 	buff, err := hex.DecodeString(G1Str)
 	if err != nil {
@@ -59,8 +81,14 @@ func init() {
 	if err != nil {
 		panic("bn256: can't decode base point on G2. Fatal error.")
 	}
+
+	handel.RegisterPublicKeyDecoder(SchemeID, UnmarshalPublicKey)
 }
 
+// SchemeID is the identifier of the bn256 BLS signature scheme in the Handel
+// packets.
+const SchemeID byte = 1
+
 // scheme implements the handel.SignatureScheme interface
 type scheme struct {
 	handel.SecretKey
@@ -76,24 +104,129 @@ func (s *scheme) Signature() handel.Signature {
 	return new(bls)
 }
 
+func (s *scheme) ID() byte {
+	return SchemeID
+}
+
+// batchScalarBits is the size of the random scalars used to verify batches.
+const batchScalarBits = 128
+
+// VerifyBatch verifies the k signatures at once with k+1 pairings, by checking
+// that the random linear combination e(sum r_i*S_i, B2) == prod
+// e(r_i*H(m_i), X_i) holds, where r_i are random scalars. An invalid
+// signature passes the check with a negligible probability. It implements the
+// handel.BatchVerifier interface.
+func (s *scheme) VerifyBatch(items []handel.VerifyItem) error {
+	hashes := make(map[string]*bn256.G1)
+	bound := new(big.Int).Lsh(big.NewInt(1), batchScalarBits)
+	var left *bn256.GT
+	var sum *bn256.G1
+	for _, item := range items {
+		pub, ok := item.PublicKey.(*publicKey)
+		if !ok {
+			return errors.New("bn256: invalid public key type")
+		}
+		sig, ok := item.Signature.(*bls)
+		if !ok || sig.e == nil {
+			return errors.New("bn256: invalid signature type")
+		}
+		HM, ok := hashes[string(item.Msg)]
+		if !ok {
+			var err error
+			if HM, err = hashedMessage(item.Msg); err != nil {
+				return err
+			}
+			hashes[string(item.Msg)] = HM
+		}
+		r, err := rand.Int(rand.Reader, bound)
+		if err != nil {
+			return err
+		}
+		rHM := new(bn256.G1).ScalarMult(HM, r)
+		rS := new(bn256.G1).ScalarMult(sig.e, r)
+		pair := bn256.Pair(rHM, pub.p)
+		if left == nil {
+			left, sum = pair, rS
+			continue
+		}
+		// the group operation of GT is noted additively
+		left.Add(left, pair)
+		sum.Add(sum, rS)
+	}
+	if left == nil {
+		return nil
+	}
+	right := bn256.Pair(sum, G2Base)
+	if !bytes.Equal(left.Marshal(), right.Marshal()) {
+		return errors.New("bn256: batch of signatures invalid")
+	}
+	return nil
+}
+
 type publicKey struct {
 	p *bn256.G2
 }
 
+// UnmarshalPublicKey returns the public key from its binary encoding, as
+// returned by its MarshalBinary method. It is registered as the
+// handel.PublicKeyDecoder of the scheme, so bn256 public keys can be read from
+// roster files.
+func UnmarshalPublicKey(buff []byte) (handel.PublicKey, error) {
+	p := new(publicKey)
+	if err := p.UnmarshalBinary(buff); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 func (p *publicKey) String() string {
 	return p.p.String()
 }
 
+func (p *publicKey) MarshalBinary() ([]byte, error) {
+	return p.p.Marshal(), nil
+}
+
+func (p *publicKey) UnmarshalBinary(buff []byte) error {
+	p.p = new(bn256.G2)
+	if _, err := p.p.Unmarshal(buff); err != nil {
+		return errors.New("bn256: public key can't unmarshal: " + err.Error())
+	}
+	return nil
+}
+
 // VerifySignature checks the given BLS signature bls on the message m using the
 // public key p by verifying that the equality e(H(m), X) == e(H(m), x*B2) ==
 // e(x*H(m), B2) == e(S, B2) holds where e is the pairing operation and B2 is
 // the base point from curve G2.
 func (p *publicKey) VerifySignature(msg []byte, sig handel.Signature) error {
-	ms := sig.(*bls)
 	HM, err := hashedMessage(msg)
 	if err != nil {
 		return err
 	}
+	return p.verify(HM, sig)
+}
+
+// VerifyPossession checks that the proof is the signature of the public key
+// hashed to G1 with the PossessionDomain. It implements the
+// handel.PossessionVerifier interface.
+func (p *publicKey) VerifyPossession(proof handel.Signature) error {
+	HP, err := HashToG1(p.p.Marshal(), PossessionDomain)
+	if err != nil {
+		return err
+	}
+	if err := p.verify(HP, proof); err != nil {
+		return errors.New("bn256: proof of possession invalid")
+	}
+	return nil
+}
+
+// verify checks that the signature is the signature of the given point of G1.
+func (p *publicKey) verify(HM *bn256.G1, sig handel.Signature) error {
+	ms, ok := sig.(*bls)
+	if !ok || ms.e == nil {
+		return errors.New("bn256: invalid signature type")
+	}
 	leftPair := bn256.Pair(HM, p.p).Marshal()
 	rightPair := bn256.Pair(ms.e, G2Base).Marshal()
 	if !bytes.Equal(leftPair, rightPair) {
@@ -109,6 +242,12 @@ func (p *publicKey) Combine(pp handel.PublicKey) handel.PublicKey {
 	return &publicKey{p3}
 }
 
+func (p *publicKey) Negate() handel.PublicKey {
+	p2 := new(bn256.G2)
+	p2.Neg(p.p)
+	return &publicKey{p2}
+}
+
 type secretKey struct {
 	*publicKey
 	s *big.Int
@@ -147,6 +286,18 @@ func (s *secretKey) Sign(msg []byte, reader io.Reader) (handel.Signature, error)
 	return &bls{p}, nil
 }
 
+// ProvePossession signs the public key hashed to G1 with the
+// PossessionDomain. It implements the handel.PossessionProver interface.
+func (s *secretKey) ProvePossession() (handel.Signature, error) {
+	hashed, err := HashToG1(s.publicKey.p.Marshal(), PossessionDomain)
+	if err != nil {
+		return nil, err
+	}
+	p := new(bn256.G1)
+	p = p.ScalarMult(hashed, s.s)
+	return &bls{p}, nil
+}
+
 type bls struct {
 	e *bn256.G1
 }
@@ -174,15 +325,57 @@ func (m *bls) Combine(ms handel.Signature) handel.Signature {
 	return &bls{e: res}
 }
 
-// hashedMessage returns the message hashed to G1
-// XXX: this should be fixed as to have a method that maps a message
-// (potentially a digest) to a point WITHOUT knowing the corresponding scalar.
+// hashedMessage returns the message hashed to G1 with the Domain.
 func hashedMessage(msg []byte) (*bn256.G1, error) {
-	h := Hash()
-	h.Write(msg)
-	hashed := h.Sum(nil)
-	reader := bytes.NewBuffer(hashed)
-	_, HM, err := bn256.RandomG1(reader)
-	return HM, err
+	return HashToG1(msg, Domain)
+}
+
+// HashToG1 maps the message to a point of G1 whose discrete logarithm is
+// unknown, using the given domain separation tag. It uses the
+// try-and-increment method: the candidate x coordinates are derived from the
+// hash of the tag, a counter and the message, until x^3 + 3 is a square modulo
+// p. The y coordinate is then the square root whose parity is given by the
+// hash. Since G1 has a cofactor of 1, any point of the curve is in G1.
+func HashToG1(msg, domain []byte) (*bn256.G1, error) {
+	x := new(big.Int)
+	y := new(big.Int)
+	rhs := new(big.Int)
+	buff := make([]byte, 64)
+	for ctr := 0; ctr < maxTries; ctr++ {
+		digest := expandHash(msg, domain, byte(ctr))
+		x.SetBytes(digest).Mod(x, p)
+		// rhs = x^3 + 3 mod p
+		rhs.Exp(x, big.NewInt(3), p).Add(rhs, curveB).Mod(rhs, p)
+		y.Exp(rhs, sqrtExp, p)
+		if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(rhs) != 0 {
+			continue
+		}
+		if y.Bit(0) != uint(digest[len(digest)-1]&1) {
+			y.Sub(p, y)
+		}
+		x.FillBytes(buff[:32])
+		y.FillBytes(buff[32:])
+		point := new(bn256.G1)
+		if _, err := point.Unmarshal(buff); err != nil {
+			return nil, err
+		}
+		return point, nil
+	}
+	return nil, errors.New("bn256: can't hash message to G1")
+}
 
+// expandHash returns 48 bytes derived from the domain, the counter and the
+// message, so the candidate x coordinate reduced modulo p is close to
+// uniform.
+func expandHash(msg, domain []byte, ctr byte) []byte {
+	var out []byte
+	for i := byte(0); len(out) < 48; i++ {
+		h := Hash()
+		h.Write([]byte{byte(len(domain))})
+		h.Write(domain)
+		h.Write([]byte{ctr, i})
+		h.Write(msg)
+		out = h.Sum(out)
+	}
+	return out[:48]
 }
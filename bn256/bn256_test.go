@@ -1,9 +1,12 @@
 package bn256
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
 
+	"github.com/ConsenSys/handel"
+	"github.com/cloudflare/bn256"
 	"github.com/stretchr/testify/require"
 )
 
@@ -48,3 +51,117 @@ func TestCombine(t *testing.T) {
 	pk3 := pk1.Combine(pk2)
 	require.NoError(t, pk3.VerifySignature(msg, sig3))
 }
+
+func TestRoster(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+	sk, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sig, err := sk.Sign(msg, nil)
+	require.NoError(t, err)
+
+	ids := []handel.Identity{handel.NewStaticIdentity(0, "127.0.0.1:3000", sk.PublicKey())}
+	var buff bytes.Buffer
+	require.NoError(t, handel.WriteRoster(&buff, handel.JSONRoster, ids))
+	reg, err := handel.ReadRoster(&buff, handel.JSONRoster, SchemeID)
+	require.NoError(t, err)
+	id, ok := reg.Identity(0)
+	require.True(t, ok)
+	require.NoError(t, id.PublicKey().VerifySignature(msg, sig))
+
+	_, err = UnmarshalPublicKey([]byte{0x01})
+	require.Error(t, err)
+}
+
+func TestHashToG1(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+	h1, err := HashToG1(msg, Domain)
+	require.NoError(t, err)
+	h2, err := HashToG1(msg, Domain)
+	require.NoError(t, err)
+	require.Equal(t, h1.Marshal(), h2.Marshal())
+
+	// other messages and other domains give other points
+	h3, err := HashToG1([]byte("Get Funky Tomorrow"), Domain)
+	require.NoError(t, err)
+	require.NotEqual(t, h1.Marshal(), h3.Marshal())
+	h4, err := HashToG1(msg, []byte("other domain"))
+	require.NoError(t, err)
+	require.NotEqual(t, h1.Marshal(), h4.Marshal())
+
+	// the points are on the curve
+	for i := 0; i < 20; i++ {
+		hm, err := HashToG1([]byte{byte(i)}, Domain)
+		require.NoError(t, err)
+		_, err = new(bn256.G1).Unmarshal(hm.Marshal())
+		require.NoError(t, err)
+	}
+}
+
+func TestPossession(t *testing.T) {
+	sk1, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sk2, err := NewSecretKey(nil)
+	require.NoError(t, err)
+
+	proof1, err := sk1.(handel.PossessionProver).ProvePossession()
+	require.NoError(t, err)
+	id1 := handel.NewStaticIdentity(0, "", sk1.PublicKey())
+	id2 := handel.NewStaticIdentity(1, "", sk2.PublicKey())
+	require.NoError(t, handel.VerifyPossession(id1, proof1))
+	require.Error(t, handel.VerifyPossession(id2, proof1))
+
+	// the signature of the public key as a message is not a proof
+	buff, err := sk1.PublicKey().(*publicKey).MarshalBinary()
+	require.NoError(t, err)
+	sig, err := sk1.Sign(buff, nil)
+	require.NoError(t, err)
+	require.Error(t, handel.VerifyPossession(id1, sig))
+
+	proof2, err := sk2.(handel.PossessionProver).ProvePossession()
+	require.NoError(t, err)
+	reg := handel.NewArrayRegistry([]handel.Identity{id1, id2})
+	_, err = handel.NewPossessionRegistry(reg, []handel.Signature{proof1, proof2})
+	require.NoError(t, err)
+	_, err = handel.NewPossessionRegistry(reg, []handel.Signature{proof1, proof1})
+	require.Error(t, err)
+}
+
+func TestVerifyBatch(t *testing.T) {
+	msgs := [][]byte{[]byte("Get Funky Tonight"), []byte("Get Funky Tomorrow")}
+	var items []handel.VerifyItem
+	var sk handel.SecretKey
+	for i := 0; i < 4; i++ {
+		var err error
+		sk, err = NewSecretKey(nil)
+		require.NoError(t, err)
+		msg := msgs[i%2]
+		sig, err := sk.Sign(msg, nil)
+		require.NoError(t, err)
+		items = append(items, handel.VerifyItem{Msg: msg, PublicKey: sk.PublicKey(), Signature: sig})
+	}
+	bv := NewSignatureScheme(sk).(handel.BatchVerifier)
+	require.NoError(t, bv.VerifyBatch(items))
+	require.Nil(t, handel.BatchVerify(bv, items))
+
+	// swapped signatures are detected
+	items[1].Signature, items[3].Signature = items[3].Signature, items[1].Signature
+	require.Error(t, bv.VerifyBatch(items))
+	errs := handel.BatchVerify(bv, items)
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	require.NoError(t, errs[2])
+	require.Error(t, errs[3])
+}
+
+func TestNegate(t *testing.T) {
+	msg := []byte("Get Funky Tonight")
+	sk1, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sk2, err := NewSecretKey(nil)
+	require.NoError(t, err)
+	sig1, err := sk1.Sign(msg, nil)
+	require.NoError(t, err)
+
+	pk := sk1.PublicKey().Combine(sk2.PublicKey()).Combine(sk2.PublicKey().Negate())
+	require.NoError(t, pk.VerifySignature(msg, sig1))
+}
@@ -1,16 +1,29 @@
 package handel
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"time"
+)
 
 // Config holds the different parameters used to configure Handel.
 type Config struct {
 	// ContributionsThreshold is the threshold of contributions the multi-signature
 	// must contain to be considered as valid. Handel will only output
-	// multi-signature containing more than this threshold of contributions.
-	// It must be typically above 50% of the number of Handel nodes. If not
-	// specified, 50% is used by default.
+	// multi-signature containing at least this threshold of contributions.
+	// It must be between 1 and the number of Handel nodes, and typically
+	// above 50% of the number of Handel nodes. If not specified, it is
+	// computed by ThresholdPolicy.
 	ContributionsThreshold int
 
+	// ThresholdPolicy computes the ContributionsThreshold from the number of
+	// Handel nodes when it is not specified. If not specified,
+	// MajorityThreshold is used by default. Use BFTThreshold to require a
+	// 2/3+1 BFT quorum instead.
+	ThresholdPolicy ThresholdPolicy
+
 	// LevelTimeout is used to decide when a Handel nodes passes to the next
 	// level even if it did not receive enough signatures. If not specified, a
 	// timeout of 500ms is used by default.
@@ -25,25 +38,107 @@ type Config struct {
 	// about its state to other Handel nodes.
 	UpdatePeriod time.Duration
 
-	// NewBitSet returns an empty bitset. This function is used to parse
+	// NewSelector returns the CandidateSelector choosing the peers to contact
+	// at each level. The seed given is derived from the message to sign and
+	// the ID of the Handel node. If not specified, the selector returned by
+	// NewRandomSelector is used by default.
+	NewSelector func(p Partitioner, seed []byte) CandidateSelector
+
+	// Evaluator scores the incoming multi-signatures to decide which ones to
+	// verify first and which ones to discard. If not specified,
+	// DefaultEvaluator is used.
+	Evaluator Evaluator
+
+	// VerifierCount is the number of workers verifying the incoming
+	// multi-signatures concurrently. If not specified, the number of CPUs is
+	// used by default.
+	VerifierCount int
+
+	// QueueSize is the maximum number of multi-signatures waiting to be
+	// verified. If not specified, a size of 100 is used by default.
+	QueueSize int
+
+	// QueuePolicy decides which multi-signature is dropped when the
+	// verification queue is full. DropLowest is used by default.
+	QueuePolicy QueuePolicy
+
+	// BatchSize is the maximum number of multi-signatures a verification
+	// worker verifies at once, when the signature scheme implements
+	// BatchVerifier. If not specified, 16 multi-signatures are verified at
+	// once by default.
+	BatchSize int
+
+	// EarlyPacketsPerLevel is the maximum number of packets kept per level
+	// when they arrive before the protocol is started or before the level is
+	// reached. They are processed once the level is reached. A negative value
+	// disables it: these packets are dropped. If not specified, 10 packets per
+	// level are kept by default.
+	EarlyPacketsPerLevel int
+
+	// OnBlacklist is called when a Handel node sent an invalid
+	// multi-signature, with the verification error. The node is then
+	// blacklisted: its packets are ignored for the rest of the protocol. It
+	// allows the application to report misbehaving nodes, for example to slash
+	// them. The origin of a packet can only be trusted if the packet arrived
+	// through the Network returned by NewAuthNetwork or NewMACNetwork: other
+	// packets are only blacklisted, never reported. OnBlacklist is called at
+	// most once per node, never after Stop, and must not block. It can be left
+	// nil.
+	OnBlacklist func(origin Identity, err error)
+
+	// Session identifies the Handel session in the packets, so packets of
+	// other sessions are rejected. It is at most 255 bytes long. If not
+	// specified, the SHA-256 hash of the message to sign is used by default.
+	Session []byte
+
+	// NewBitSet returns an empty bitset of the given bitlength. This function
+	// is used to create the bitsets of the multi-signatures and to parse
 	// incoming packets containing bitsets.
-	NewBitSet func() BitSet
+	NewBitSet func(bitlength int) BitSet
 }
 
 // DefaultConfig returns a default configuration for Handel.
 func DefaultConfig(size int) *Config {
 	return &Config{
 		ContributionsThreshold: DefaultContributionsThreshold(size),
+		ThresholdPolicy:        DefaultThresholdPolicy,
 		CandidateCount:         DefaultCandidateCount,
 		LevelTimeout:           DefaultLevelTimeout,
 		UpdatePeriod:           DefaultUpdatePeriod,
+		NewSelector:            DefaultSelector,
+		Evaluator:              DefaultEvaluator,
+		VerifierCount:          DefaultVerifierCount,
+		QueueSize:              DefaultQueueSize,
+		BatchSize:              DefaultBatchSize,
+		EarlyPacketsPerLevel:   DefaultEarlyPacketsPerLevel,
 		NewBitSet:              DefaultBitSet,
 	}
 }
 
-// DefaultContributionsThreshold returns the default contributions threshold.
+// ThresholdPolicy returns the contributions threshold to use for the given
+// number of Handel nodes.
+type ThresholdPolicy func(size int) int
+
+// MajorityThreshold is the ThresholdPolicy requiring the contributions of a
+// strict majority of the Handel nodes.
+func MajorityThreshold(size int) int {
+	return size/2 + 1
+}
+
+// BFTThreshold is the ThresholdPolicy requiring the contributions of more than
+// two thirds of the Handel nodes, i.e. the 2f+1 quorum of BFT protocols
+// tolerating f faulty nodes out of 3f+1.
+func BFTThreshold(size int) int {
+	return 2*size/3 + 1
+}
+
+// DefaultThresholdPolicy is the default ThresholdPolicy used by Handel.
+var DefaultThresholdPolicy ThresholdPolicy = MajorityThreshold
+
+// DefaultContributionsThreshold returns the default contributions threshold,
+// i.e. a strict majority of the Handel nodes.
 func DefaultContributionsThreshold(size int) int {
-	panic("not implemented yet")
+	return DefaultThresholdPolicy(size)
 }
 
 // DefaultLevelTimeout is the default level timeout used by Handel.
@@ -55,14 +150,42 @@ const DefaultCandidateCount = 10
 // DefaultUpdatePeriod is the default update period used by Handel.
 const DefaultUpdatePeriod = 50 * time.Millisecond
 
+// DefaultVerifierCount is the default number of verification workers used by
+// Handel.
+var DefaultVerifierCount = runtime.NumCPU()
+
+// DefaultQueueSize is the default size of the verification queue used by
+// Handel.
+const DefaultQueueSize = 100
+
+// DefaultBatchSize is the default number of multi-signatures verified at once
+// by Handel when the signature scheme implements BatchVerifier.
+const DefaultBatchSize = 16
+
+// DefaultEarlyPacketsPerLevel is the default number of early packets kept per
+// level by Handel.
+const DefaultEarlyPacketsPerLevel = 10
+
 // DefaultBitSet returns the default implementation used by Handel, i.e. the
 // WilffBitSet
-var DefaultBitSet = func() BitSet { return new(WilffBitSet) }
+var DefaultBitSet = NewWilffBitset
+
+// DefaultEvaluator is the default Evaluator used by Handel. It scores the
+// multi-signatures by the number of new contributions they bring, giving
+// priority to the lower levels.
+var DefaultEvaluator Evaluator = new(gainEvaluator)
+
+// DefaultSelector returns the default CandidateSelector used by Handel, i.e.
+// the one returned by NewRandomSelector.
+var DefaultSelector = NewRandomSelector
 
 func mergeWithDefault(c *Config, size int) *Config {
 	c2 := *c
+	if c.ThresholdPolicy == nil {
+		c2.ThresholdPolicy = DefaultThresholdPolicy
+	}
 	if c.ContributionsThreshold == 0 {
-		c2.ContributionsThreshold = DefaultContributionsThreshold(size)
+		c2.ContributionsThreshold = c2.ThresholdPolicy(size)
 	}
 	if c.CandidateCount == 0 {
 		c2.CandidateCount = DefaultCandidateCount
@@ -73,8 +196,115 @@ func mergeWithDefault(c *Config, size int) *Config {
 	if c.UpdatePeriod == 0*time.Second {
 		c2.UpdatePeriod = DefaultUpdatePeriod
 	}
+	if c.NewSelector == nil {
+		c2.NewSelector = DefaultSelector
+	}
+	if c.Evaluator == nil {
+		c2.Evaluator = DefaultEvaluator
+	}
+	if c.VerifierCount == 0 {
+		c2.VerifierCount = DefaultVerifierCount
+	}
+	if c.QueueSize == 0 {
+		c2.QueueSize = DefaultQueueSize
+	}
+	if c.BatchSize == 0 {
+		c2.BatchSize = DefaultBatchSize
+	}
+	if c.EarlyPacketsPerLevel == 0 {
+		c2.EarlyPacketsPerLevel = DefaultEarlyPacketsPerLevel
+	}
 	if c.NewBitSet == nil {
 		c2.NewBitSet = DefaultBitSet
 	}
 	return &c2
 }
+
+// ConfigError describes an invalid field of a Config. Config.Validate returns
+// them in ConfigErrors.
+type ConfigError struct {
+	// Field is the name of the invalid field
+	Field string
+	// Reason describes why the field is invalid
+	Reason string
+}
+
+func (c *ConfigError) Error() string {
+	return fmt.Sprintf("handel: invalid config: %s %s", c.Field, c.Reason)
+}
+
+// ConfigErrors holds all the invalid fields of a config.
+type ConfigErrors []*ConfigError
+
+func (c ConfigErrors) Error() string {
+	reasons := make([]string, len(c))
+	for i, err := range c {
+		reasons[i] = err.Field + " " + err.Reason
+	}
+	return "handel: invalid config: " + strings.Join(reasons, ", ")
+}
+
+// Validate checks that the config is consistent and can be used to run Handel
+// over a registry of the given size. It returns ConfigErrors describing every
+// invalid field found, if any. Validate must be called on a config whose
+// unspecified fields have been filled with the default values, as NewHandel
+// does.
+func (c *Config) Validate(registrySize int) error {
+	var errs ConfigErrors
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+	if registrySize < 1 {
+		invalid("registry size", "must be positive, got %d", registrySize)
+	} else if c.ContributionsThreshold < 1 || c.ContributionsThreshold > registrySize {
+		invalid("ContributionsThreshold", "must be between 1 and %d, got %d",
+			registrySize, c.ContributionsThreshold)
+	}
+	if c.LevelTimeout <= 0 {
+		invalid("LevelTimeout", "must be positive, got %s", c.LevelTimeout)
+	}
+	if c.UpdatePeriod <= 0 {
+		invalid("UpdatePeriod", "must be positive, got %s", c.UpdatePeriod)
+	} else if c.LevelTimeout > 0 && c.LevelTimeout < c.UpdatePeriod {
+		invalid("LevelTimeout", "must not be shorter than UpdatePeriod (%s), got %s",
+			c.UpdatePeriod, c.LevelTimeout)
+	}
+	if c.CandidateCount <= 0 {
+		invalid("CandidateCount", "must be positive, got %d", c.CandidateCount)
+	}
+	if c.VerifierCount <= 0 {
+		invalid("VerifierCount", "must be positive, got %d", c.VerifierCount)
+	}
+	if c.QueueSize <= 0 {
+		invalid("QueueSize", "must be positive, got %d", c.QueueSize)
+	}
+	if c.QueuePolicy != DropLowest && c.QueuePolicy != DropNewest {
+		invalid("QueuePolicy", "is unknown: %d", c.QueuePolicy)
+	}
+	if c.BatchSize <= 0 {
+		invalid("BatchSize", "must be positive, got %d", c.BatchSize)
+	}
+	if c.NewSelector == nil {
+		invalid("NewSelector", "must not be nil")
+	}
+	if c.Evaluator == nil {
+		invalid("Evaluator", "must not be nil")
+	}
+	if len(c.Session) > math.MaxUint8 {
+		invalid("Session", "must be at most %d bytes, got %d", math.MaxUint8, len(c.Session))
+	}
+	if c.NewBitSet == nil {
+		invalid("NewBitSet", "must not be nil")
+	} else if registrySize >= 1 {
+		if bs := c.NewBitSet(registrySize); bs == nil {
+			invalid("NewBitSet", "returned a nil bitset")
+		} else if bs.BitLength() != registrySize {
+			invalid("NewBitSet", "returned a bitset of length %d instead of %d",
+				bs.BitLength(), registrySize)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigThresholds(t *testing.T) {
+	var tests = []struct {
+		size     int
+		majority int
+		bft      int
+	}{
+		{1, 1, 1},
+		{2, 2, 2},
+		{4, 3, 3},
+		{7, 4, 5},
+		{10, 6, 7},
+		{100, 51, 67},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.majority, MajorityThreshold(test.size), "size %d", test.size)
+		require.Equal(t, test.bft, BFTThreshold(test.size), "size %d", test.size)
+		require.Equal(t, test.majority, DefaultConfig(test.size).ContributionsThreshold)
+	}
+}
+
+func TestConfigMergeWithDefault(t *testing.T) {
+	c := mergeWithDefault(&Config{}, 10)
+	require.NoError(t, c.Validate(10))
+	require.Equal(t, 6, c.ContributionsThreshold)
+
+	c = mergeWithDefault(&Config{ThresholdPolicy: BFTThreshold}, 10)
+	require.Equal(t, 7, c.ContributionsThreshold)
+
+	c = mergeWithDefault(&Config{ContributionsThreshold: 10}, 10)
+	require.Equal(t, 10, c.ContributionsThreshold)
+}
+
+func TestConfigValidate(t *testing.T) {
+	var tests = []struct {
+		field  string
+		size   int
+		modify func(c *Config)
+	}{
+		{"", 10, func(c *Config) {}},
+		{"registry size", 0, func(c *Config) {}},
+		{"ContributionsThreshold", 10, func(c *Config) { c.ContributionsThreshold = 11 }},
+		{"ContributionsThreshold", 10, func(c *Config) { c.ContributionsThreshold = -1 }},
+		{"LevelTimeout", 10, func(c *Config) { c.LevelTimeout = -time.Second }},
+		{"UpdatePeriod", 10, func(c *Config) { c.UpdatePeriod = -time.Second }},
+		{"LevelTimeout", 10, func(c *Config) { c.LevelTimeout = c.UpdatePeriod / 2 }},
+		{"CandidateCount", 10, func(c *Config) { c.CandidateCount = -1 }},
+		{"VerifierCount", 10, func(c *Config) { c.VerifierCount = -1 }},
+		{"QueueSize", 10, func(c *Config) { c.QueueSize = -1 }},
+		{"QueuePolicy", 10, func(c *Config) { c.QueuePolicy = 3 }},
+		{"BatchSize", 10, func(c *Config) { c.BatchSize = -1 }},
+		{"", 10, func(c *Config) { c.EarlyPacketsPerLevel = -1 }},
+		{"NewBitSet", 10, func(c *Config) { c.NewBitSet = func(int) BitSet { return nil } }},
+		{"NewBitSet", 10, func(c *Config) { c.NewBitSet = func(int) BitSet { return NewWilffBitset(1) } }},
+		{"Session", 10, func(c *Config) { c.Session = make([]byte, 256) }},
+	}
+	for i, test := range tests {
+		c := mergeWithDefault(&Config{}, 10)
+		test.modify(c)
+		err := c.Validate(test.size)
+		if test.field == "" {
+			require.NoError(t, err, "test %d", i)
+			continue
+		}
+		require.Error(t, err, "test %d", i)
+		cerrs, ok := err.(ConfigErrors)
+		require.True(t, ok, "test %d", i)
+		require.Len(t, cerrs, 1, "test %d", i)
+		require.Equal(t, test.field, cerrs[0].Field, "test %d", i)
+		require.Contains(t, err.Error(), test.field)
+	}
+
+	// all the invalid fields are reported at once
+	c := mergeWithDefault(&Config{}, 10)
+	c.CandidateCount = -1
+	c.QueueSize = -1
+	c.Session = make([]byte, 256)
+	err := c.Validate(10)
+	cerrs, ok := err.(ConfigErrors)
+	require.True(t, ok)
+	var fields []string
+	for _, cerr := range cerrs {
+		fields = append(fields, cerr.Field)
+	}
+	require.Equal(t, []string{"CandidateCount", "QueueSize", "Session"}, fields)
+}
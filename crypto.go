@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
 )
 
 // PublicKey holds methods to verify a signature and to combine multiple public
@@ -16,6 +17,10 @@ type PublicKey interface {
 	// produced by both individual public keys can be verified by the combined
 	// public key
 	Combine(PublicKey) PublicKey
+	// Negate returns the opposite of the public key, such that combining a
+	// public key with its opposite cancels it out. It allows to subtract a
+	// public key from a combination of public keys.
+	Negate() PublicKey
 }
 
 // SecretKey holds methods to produce a valid signature that can be verified
@@ -31,6 +36,9 @@ type SecretKey interface {
 // signatures suitable for unmarshalling
 type SignatureScheme interface {
 	SecretKey
+	// ID returns the identifier of the signature scheme. It is written in the
+	// packets so Handel nodes using different schemes can't be mixed up.
+	ID() byte
 	// Signature returns a fresh empty signature suitable for unmarshaling
 	Signature() Signature
 }
@@ -54,48 +62,77 @@ type MultiSignature struct {
 	Signature
 }
 
-// MarshalBinary implements the binary.Marshaller interface
+// MarshalBinary implements the binary.Marshaller interface. The encoding starts
+// with the WireVersion.
 func (m *MultiSignature) MarshalBinary() ([]byte, error) {
-	var b bytes.Buffer
 	bs, err := m.BitSet.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
-	length := uint16(len(bs))
-
 	sig, err := m.Signature.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
-
-	binary.Write(&b, binary.BigEndian, length)
-	b.Write(bs)
-	b.Write(sig)
-	return b.Bytes(), nil
+	return encodeMultiSig(bs, sig)
 }
 
 // Unmarshal reads a multisignature from the given slice, using the signature
-// and bitset interface given.
+// and bitset interface given. It returns ErrWireVersion if the multisignature
+// is encoded with another version than WireVersion.
 func (m *MultiSignature) Unmarshal(b []byte, s Signature, bs BitSet) error {
-	var buff = bytes.NewBuffer(b)
-	var length uint16
-	if err := binary.Read(buff, binary.BigEndian, &length); err != nil {
+	bitset, sig, err := decodeMultiSig(b)
+	if err != nil {
 		return err
 	}
+	return m.unmarshalParts(bitset, sig, s, bs)
+}
 
-	bitset := buff.Next(int(length))
-	if len(bitset) < int(length) {
-		return errors.New("bitset received smaller than expected")
-	}
+// unmarshalParts reads the multisignature from the binary encodings of its
+// bitset and signature.
+func (m *MultiSignature) unmarshalParts(bitset, sig []byte, s Signature, bs BitSet) error {
 	if err := bs.UnmarshalBinary(bitset); err != nil {
 		return err
 	}
-
-	if err := s.UnmarshalBinary(buff.Bytes()); err != nil {
+	if err := s.UnmarshalBinary(sig); err != nil {
 		return err
 	}
-
 	m.BitSet = bs
 	m.Signature = s
 	return nil
 }
+
+// encodeMultiSig returns the binary encoding of a multisignature from the
+// binary encodings of its bitset and signature.
+func encodeMultiSig(bitset, sig []byte) ([]byte, error) {
+	if len(bitset) > math.MaxUint16 {
+		return nil, errors.New("handel: bitset too long")
+	}
+	var b bytes.Buffer
+	b.WriteByte(WireVersion)
+	binary.Write(&b, binary.BigEndian, uint16(len(bitset)))
+	b.Write(bitset)
+	b.Write(sig)
+	return b.Bytes(), nil
+}
+
+// decodeMultiSig splits the binary encoding of a multisignature into the
+// binary encodings of its bitset and signature.
+func decodeMultiSig(b []byte) (bitset, sig []byte, err error) {
+	var buff = bytes.NewBuffer(b)
+	version, err := buff.ReadByte()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != WireVersion {
+		return nil, nil, ErrWireVersion
+	}
+	var length uint16
+	if err := binary.Read(buff, binary.BigEndian, &length); err != nil {
+		return nil, nil, err
+	}
+	bitset = buff.Next(int(length))
+	if len(bitset) < int(length) {
+		return nil, nil, errors.New("bitset received smaller than expected")
+	}
+	return bitset, buff.Bytes(), nil
+}
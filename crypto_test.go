@@ -20,5 +20,9 @@ func TestMultiSignatureMarshalling(t *testing.T) {
 	ms2 := new(MultiSignature)
 	err = ms2.Unmarshal(buff, new(fakeSig), new(WilffBitSet))
 	require.NoError(t, err)
+	require.Equal(t, ms.BitSet, ms2.BitSet)
 
+	buff[0] = WireVersion + 1
+	err = new(MultiSignature).Unmarshal(buff, new(fakeSig), new(WilffBitSet))
+	require.Equal(t, ErrWireVersion, err)
 }
@@ -0,0 +1,74 @@
+package handel
+
+// earlyStore keeps the multi-signatures received for levels a Handel node has
+// not reached yet, so they can be processed once the level is reached instead
+// of waiting for the peers to send them again. Only the multi-signature with
+// the most contributions is kept per origin, and at most size of them are kept
+// per level. Nothing is kept if size is not positive.
+type earlyStore struct {
+	size   int
+	levels map[int][]*pendingSig
+}
+
+func newEarlyStore(size int) *earlyStore {
+	return &earlyStore{
+		size:   size,
+		levels: make(map[int][]*pendingSig),
+	}
+}
+
+// put stores the multi-signature. It replaces the one from the same origin if
+// the new one has more contributions. If the level is full, the
+// multi-signature with the fewest contributions is dropped, which can be the
+// new one. It returns true if the multi-signature has been stored.
+func (e *earlyStore) put(sp *pendingSig) bool {
+	stored := e.levels[sp.level]
+	for i, p := range stored {
+		if p.origin != sp.origin {
+			continue
+		}
+		if p.ms.Cardinality() < sp.ms.Cardinality() {
+			stored[i] = sp
+			return true
+		}
+		return false
+	}
+	if len(stored) < e.size {
+		e.levels[sp.level] = append(stored, sp)
+		return true
+	}
+	lowest := -1
+	for i, p := range stored {
+		if lowest < 0 || p.ms.Cardinality() < stored[lowest].ms.Cardinality() {
+			lowest = i
+		}
+	}
+	if lowest < 0 || stored[lowest].ms.Cardinality() >= sp.ms.Cardinality() {
+		return false
+	}
+	stored[lowest] = sp
+	return true
+}
+
+// take removes and returns the multi-signatures stored for the given level.
+func (e *earlyStore) take(level int) []*pendingSig {
+	stored := e.levels[level]
+	delete(e.levels, level)
+	return stored
+}
+
+// remove discards all the multi-signatures from the given origin.
+func (e *earlyStore) remove(origin int) {
+	for l, stored := range e.levels {
+		kept := stored[:0]
+		for _, p := range stored {
+			if p.origin != origin {
+				kept = append(kept, p)
+			}
+		}
+		for i := len(kept); i < len(stored); i++ {
+			stored[i] = nil
+		}
+		e.levels[l] = kept
+	}
+}
@@ -0,0 +1,34 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEarlyStore(t *testing.T) {
+	e := newEarlyStore(2)
+	require.True(t, e.put(&pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 0)}))
+	// only the best multi-signature is kept per origin
+	require.False(t, e.put(&pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 1)}))
+	require.True(t, e.put(&pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 0, 1)}))
+	require.True(t, e.put(&pendingSig{origin: 5, level: 3, ms: newTestMultiSig(4, 1)}))
+	// the level is full: the worst multi-signature is dropped
+	require.False(t, e.put(&pendingSig{origin: 6, level: 3, ms: newTestMultiSig(4, 2)}))
+	require.True(t, e.put(&pendingSig{origin: 7, level: 3, ms: newTestMultiSig(4, 1, 2, 3)}))
+	require.True(t, e.put(&pendingSig{origin: 2, level: 2, ms: newTestMultiSig(2, 0)}))
+
+	e.remove(7)
+	stored := e.take(3)
+	require.Len(t, stored, 1)
+	require.Equal(t, 4, stored[0].origin)
+	require.Equal(t, 2, stored[0].ms.Cardinality())
+	require.Len(t, e.take(3), 0)
+	require.Len(t, e.take(2), 1)
+}
+
+func TestEarlyStoreDisabled(t *testing.T) {
+	e := newEarlyStore(-1)
+	require.False(t, e.put(&pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 0)}))
+	require.Len(t, e.take(3), 0)
+}
@@ -0,0 +1,124 @@
+package handel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// EpochRegistry is a Registry whose set of identities changes at each epoch.
+// Used as a Registry, it gives the identities of the current epoch. Since the
+// set can change at any time, a Handel session must use a fixed snapshot of
+// it: NewHandel pins the session to the current epoch when given an
+// EpochRegistry, so a membership change in the middle of the session does not
+// shift the mapping of the IDs to the bits of the multi-signatures.
+type EpochRegistry interface {
+	Registry
+	// Epoch returns the current epoch.
+	Epoch() uint64
+	// Load sets the identities of the given epoch, which becomes the current
+	// epoch. The epoch must be more recent than the current one, and the
+	// identities must be sorted by ID, the ID of each identity being its
+	// index in the slice.
+	Load(epoch uint64, ids []Identity) error
+	// At returns the set of identities of the given epoch, or false if the
+	// epoch is unknown or too old to be kept in the history.
+	At(epoch uint64) (Registry, bool)
+	// Snapshot returns the set of identities of the current epoch. Unlike the
+	// EpochRegistry itself, the returned Registry never changes.
+	Snapshot() Registry
+}
+
+// epochSet is the immutable set of identities of one epoch.
+type epochSet struct {
+	Registry
+	epoch uint64
+}
+
+// Epoch returns the epoch of the set of identities.
+func (e *epochSet) Epoch() uint64 {
+	return e.epoch
+}
+
+// epochRegistry keeps the sets of identities of the last epochs. The current
+// set is read atomically, without locking.
+type epochRegistry struct {
+	sync.Mutex
+	// *epochSet of the current epoch
+	current atomic.Value
+	// number of epochs kept
+	history int
+	// sets of the epochs kept, sorted by epoch
+	sets []*epochSet
+}
+
+// NewEpochRegistry returns an EpochRegistry keeping the sets of identities of
+// the given number of last epochs, at least one. It is empty until the first
+// call to Load.
+func NewEpochRegistry(history int) EpochRegistry {
+	if history < 1 {
+		history = 1
+	}
+	e := &epochRegistry{history: history}
+	e.current.Store(&epochSet{Registry: NewArrayRegistry(nil)})
+	return e
+}
+
+func (e *epochRegistry) Load(epoch uint64, ids []Identity) error {
+	for i, id := range ids {
+		if id.ID() != i {
+			return fmt.Errorf("handel: identity at index %d has ID %d", i, id.ID())
+		}
+	}
+	e.Lock()
+	defer e.Unlock()
+	if len(e.sets) > 0 && epoch <= e.sets[len(e.sets)-1].epoch {
+		return errors.New("handel: epoch not more recent than the current one")
+	}
+	set := &epochSet{
+		Registry: NewArrayRegistry(append([]Identity{}, ids...)),
+		epoch:    epoch,
+	}
+	e.sets = append(e.sets, set)
+	if len(e.sets) > e.history {
+		e.sets = append([]*epochSet{}, e.sets[len(e.sets)-e.history:]...)
+	}
+	e.current.Store(set)
+	return nil
+}
+
+func (e *epochRegistry) At(epoch uint64) (Registry, bool) {
+	e.Lock()
+	defer e.Unlock()
+	for _, set := range e.sets {
+		if set.epoch == epoch {
+			return set, true
+		}
+	}
+	return nil, false
+}
+
+func (e *epochRegistry) Snapshot() Registry {
+	return e.load()
+}
+
+func (e *epochRegistry) Epoch() uint64 {
+	return e.load().epoch
+}
+
+func (e *epochRegistry) Size() int {
+	return e.load().Size()
+}
+
+func (e *epochRegistry) Identity(idx int) (Identity, bool) {
+	return e.load().Identity(idx)
+}
+
+func (e *epochRegistry) Identities(from, to int) ([]Identity, bool) {
+	return e.load().Identities(from, to)
+}
+
+func (e *epochRegistry) load() *epochSet {
+	return e.current.Load().(*epochSet)
+}
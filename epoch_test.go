@@ -0,0 +1,48 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEpochRegistry(t *testing.T) {
+	e := NewEpochRegistry(2)
+	require.Equal(t, 0, e.Size())
+
+	ids1 := fakeIdentities(4)
+	require.NoError(t, e.Load(1, ids1))
+	snapshot := e.Snapshot()
+	ids2 := fakeIdentities(10)
+	require.NoError(t, e.Load(2, ids2))
+	require.Equal(t, uint64(2), e.Epoch())
+
+	var tests = []registryTest{
+		{func() Registry { return e }, 10, 5, true, ids2[5], 0, 3, true, ids2[0:3]},
+		{func() Registry { return snapshot }, 4, 5, false, nil, 0, 4, true, ids1},
+	}
+	testRegistryTests(t, tests)
+
+	require.Error(t, e.Load(2, ids1))
+	require.Error(t, e.Load(3, []Identity{&fakeIdentity{1}}))
+
+	require.NoError(t, e.Load(3, ids1))
+	_, ok := e.At(1)
+	require.False(t, ok)
+	r, ok := e.At(2)
+	require.True(t, ok)
+	require.Equal(t, 10, r.Size())
+}
+
+func TestHandelEpochRegistry(t *testing.T) {
+	e := NewEpochRegistry(1)
+	require.NoError(t, e.Load(1, fakeIdentities(4)))
+	id, _ := e.Identity(0)
+	h, err := NewHandel(newTestNetwork().node(id.Address()), e, id, new(fakeScheme), msg, testConfig())
+	require.NoError(t, err)
+
+	// a membership change does not change the session's registry
+	require.NoError(t, e.Load(2, fakeIdentities(8)))
+	require.Equal(t, 4, h.reg.Size())
+	require.Equal(t, 4, h.Aggregate().BitLength())
+}
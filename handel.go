@@ -1,9 +1,11 @@
 package handel
 
 import (
+	"bytes"
+	"context"
 	"errors"
-	"math"
 	"sync"
+	"time"
 )
 
 // Handel is the principal struct that performs the large scale multi-signature
@@ -16,31 +18,76 @@ type Handel struct {
 	net Network
 	// Registry holding access to all Handel node's identities
 	reg Registry
+	// Identity of this Handel node
+	id Identity
+	// splits the registry in the levels of the Handel tree
+	part Partitioner
+	// selects the peers to contact at each level
+	sel CandidateSelector
+	// multi-signatures waiting to be verified
+	queue *verifQueue
+	// signals the verification workers that a multi-signature is queued
+	queued chan struct{}
+	// multi-signatures received for levels not reached yet
+	early *earlyStore
+	// origins which sent invalid multi-signatures, whose packets are ignored
+	blacklist map[int]bool
 	// signature scheme used for this Handel protocol
 	scheme SignatureScheme
 	// Message that is being signed during the Handel protocol
 	msg []byte
-	// incremental aggregated signature cached by this handel node
-	aggregate Signature
+	// identifier of the session written in the packets
+	session []byte
+	// incremental aggregated multi-signature cached by this handel node. It
+	// combines the best multi-signatures of all levels.
+	aggregate *MultiSignature
+	// state of each level of the Handel tree, indexed by level
+	levels []*level
 	// incremental level at which this handel node is at
 	level uint16
-	// level at which this handel node is at
+	// timer triggering the passage to the next level
+	timer *time.Timer
+	// true if the protocol has been started
+	started bool
+	// true if the protocol has been stopped
+	stopped bool
+	// closed when the protocol is stopped
+	done chan struct{}
 	// channel to exposes multi-signatures to the user
 	out chan MultiSignature
 }
 
+// levelUpdate is a packet to send out to some peers of a level.
+type levelUpdate struct {
+	ids []Identity
+	p   *Packet
+}
+
 // NewHandel returns a Handle interface that uses the given network and
-// registry. The signature scheme is the one to use for this Handel protocol,
-// and the message is the message to multi-sign.The first config in the slice is
-// taken if not nil. Otherwise, the default config generated by DefaultConfig()
-// is used.
-func NewHandel(n Network, r Registry, s SignatureScheme, msg []byte,
+// registry. The identity is the one of this Handel node in the registry. The
+// signature scheme is the one to use for this Handel protocol, and the message
+// is the message to multi-sign.The first config in the slice is taken if not
+// nil. Otherwise, the default config generated by DefaultConfig() is used.
+// Handel registers itself as a Listener to the network. If the registry is an
+// EpochRegistry, Handel uses the identities of its current epoch for the whole
+// protocol. NewHandel returns an error if the config is invalid, see
+// Config.Validate.
+func NewHandel(n Network, r Registry, id Identity, s SignatureScheme, msg []byte,
 	conf ...*Config) (*Handel, error) {
+	if er, ok := r.(EpochRegistry); ok {
+		r = er.Snapshot()
+	}
+	if _, ok := r.Identity(id.ID()); !ok {
+		return nil, errors.New("handel: identity not in the registry")
+	}
 	h := &Handel{
-		net:    n,
-		reg:    r,
-		scheme: s,
-		msg:    msg,
+		net:       n,
+		reg:       r,
+		id:        id,
+		scheme:    s,
+		msg:       msg,
+		done:      make(chan struct{}),
+		blacklist: make(map[int]bool),
 	}
 
 	if len(conf) > 0 && conf[0] != nil {
@@ -48,58 +95,563 @@ func NewHandel(n Network, r Registry, s SignatureScheme, msg []byte,
 	} else {
 		h.c = DefaultConfig(r.Size())
 	}
+	if err := h.c.Validate(r.Size()); err != nil {
+		return nil, err
+	}
+	h.session = sessionID(h.c.Session, msg)
 
 	ms, err := s.Sign(msg, nil)
 	if err != nil {
 		return nil, err
 	}
-	h.aggregate = ms
+
+	h.part = NewBinPartitioner(id.ID(), r, h.c.NewBitSet)
+	h.sel = h.c.NewSelector(h.part, selectorSeed(msg, id.ID()))
+	h.queue = newVerifQueue(h.c.Evaluator, h.c.QueueSize, h.c.QueuePolicy)
+	h.queued = make(chan struct{}, h.c.QueueSize)
+	h.early = newEarlyStore(h.c.EarlyPacketsPerLevel)
+	h.levels = make([]*level, h.maxLevel()+1)
+	for l := range h.levels {
+		min, max, err := h.part.RangeLevel(l)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := h.part.IdentitiesAt(l)
+		if err != nil {
+			return nil, err
+		}
+		h.levels[l] = newLevel(l, min, max, nodes)
+	}
+	own := h.c.NewBitSet(1)
+	own.Set(0, true)
+	h.levels[0].best = &MultiSignature{BitSet: own, Signature: ms}
+	h.aggregate = h.combine(len(h.levels), 0, r.Size())
+	h.out = make(chan MultiSignature, len(h.levels))
+	n.RegisterListener(h)
 	return h, nil
 }
 
 // NewPacket implements the Listener interface for the network.
 // It returns an error in case the packet is not a properly formatted packet or
-// contains erroneous data.
+// contains erroneous data. Packets from a blacklisted origin, or arriving after
+// Stop, are ignored. Packets arriving before Start or for a level this Handel
+// node has not reached yet are kept aside, up to EarlyPacketsPerLevel per
+// level, until the level is reached. Valid packets are only queued:
+// the verification workers verify them in the background by order of score.
 func (h *Handel) NewPacket(p *Packet) error {
-	h.Lock()
-	defer h.Unlock()
-
-	_, err := h.parsePacket(p)
+	// parsing only reads fields set once in NewHandel: it runs without the
+	// lock so the unmarshalling does not delay the other goroutines
+	ms, err := h.parsePacket(p)
 	if err != nil {
 		return err
 	}
+	var ind Signature
+	if len(p.IndividualSig) > 0 {
+		ind = h.scheme.Signature()
+		if err := ind.UnmarshalBinary(p.IndividualSig); err != nil {
+			return err
+		}
+	}
+	sp := &pendingSig{
+		origin: int(p.Origin),
+		level:  int(p.Level),
+		ms:     ms,
+		ind:    ind,
+
+		authenticated: p.authenticated,
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	if h.stopped || h.blacklist[sp.origin] {
+		return nil
+	}
+	if !h.started || sp.level > int(h.level) {
+		h.early.put(sp)
+	} else if h.queue.push(sp, h.levels) {
+		h.signal()
+	}
 	return nil
 }
 
-// Start the Handel protocol
+// signal wakes up a verification worker to empty the queue.
+func (h *Handel) signal() {
+	select {
+	case h.queued <- struct{}{}:
+	default:
+		// enough signals are pending for the workers to empty the queue
+	}
+}
+
+// Start the Handel protocol. It is equivalent to
+// StartContext(context.Background()).
 func (h *Handel) Start() {
+	h.StartContext(context.Background())
+}
+
+// StartContext starts the Handel protocol, which runs until Stop is called or
+// the given context is done.
+func (h *Handel) StartContext(ctx context.Context) {
+	h.Lock()
+	if h.started || h.stopped {
+		h.Unlock()
+		return
+	}
+	h.started = true
+	h.output()
+	updates := h.checkLevel()
+	h.Unlock()
+	h.send(updates)
+	go h.run(ctx)
+	for i := 0; i < h.c.VerifierCount; i++ {
+		go h.verifyLoop()
+	}
+}
+
+// Stop the Handel protocol: timers are halted, no more packets are sent out or
+// processed, and the channel returned by FinalSignatures is closed. Stop can be
+// called multiple times.
+func (h *Handel) Stop() {
+	h.Lock()
+	defer h.Unlock()
+	if h.stopped {
+		return
+	}
+	h.stopped = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	close(h.done)
+	close(h.out)
+}
+
+// FinalSignatures returns the channel on which Handel outputs each new best
+// aggregated multi-signature containing at least ContributionsThreshold
+// contributions. The channel is closed when the protocol is stopped. If the
+// application does not read fast enough, the oldest multi-signatures are
+// dropped in favor of the newest ones.
+func (h *Handel) FinalSignatures() <-chan MultiSignature {
+	return h.out
+}
+
+// Aggregate returns the best multi-signature this Handel node has aggregated
+// so far.
+func (h *Handel) Aggregate() *MultiSignature {
+	h.Lock()
+	defer h.Unlock()
+	return h.aggregate
+}
+
+// run periodically sends the multi-signatures of each level started so far to
+// some new peers of the level, until the protocol is stopped or the context is
+// done.
+func (h *Handel) run(ctx context.Context) {
+	ticker := time.NewTicker(h.c.UpdatePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ctx.Done():
+			h.Stop()
+			return
+		case <-ticker.C:
+		}
+		h.Lock()
+		var updates []*levelUpdate
+		for l := 1; l <= int(h.level); l++ {
+			if u := h.levelUpdate(l); u != nil {
+				updates = append(updates, u)
+			}
+		}
+		h.Unlock()
+		h.send(updates)
+	}
+}
+
+// checkLevel passes to the next levels as long as the multi-signature to send
+// out at the next level is complete, i.e. all levels up to the current one are
+// complete. It returns the updates to send to the peers of the levels started.
+// This method is NOT thread-safe and only meant for internal use.
+func (h *Handel) checkLevel() []*levelUpdate {
+	var updates []*levelUpdate
+	for int(h.level) < h.maxLevel() && h.completeUpTo(int(h.level)) {
+		updates = append(updates, h.nextLevel()...)
+	}
+	return updates
+}
+
+// nextLevel passes to the next level, queues the multi-signatures received
+// early for that level and arms the timer of the new level. It returns the
+// update to send to the peers of the new level. This method is NOT thread-safe
+// and only meant for internal use.
+func (h *Handel) nextLevel() []*levelUpdate {
+	h.level++
+	for _, sp := range h.early.take(int(h.level)) {
+		if h.queue.push(sp, h.levels) {
+			h.signal()
+		}
+	}
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	if int(h.level) < h.maxLevel() {
+		current := h.level
+		h.timer = time.AfterFunc(h.c.LevelTimeout, func() {
+			h.levelTimeout(current)
+		})
+	}
+	if u := h.levelUpdate(int(h.level)); u != nil {
+		return []*levelUpdate{u}
+	}
+	return nil
+}
+
+// levelTimeout passes to the next level if this Handel node is still at the
+// given level.
+func (h *Handel) levelTimeout(l uint16) {
+	h.Lock()
+	if h.stopped || h.level != l {
+		h.Unlock()
+		return
+	}
+	updates := h.nextLevel()
+	updates = append(updates, h.checkLevel()...)
+	h.Unlock()
+	h.send(updates)
+}
+
+// levelUpdate returns the packet containing the multi-signature of this node's
+// subtree at the given level, alongside the next peers to send it to, or nil
+// if the level has no peers. This method is NOT thread-safe and only meant for
+// internal use.
+func (h *Handel) levelUpdate(l int) *levelUpdate {
+	lvl := h.levels[l]
+	if len(lvl.nodes) == 0 {
+		return nil
+	}
+	min, max, err := h.part.RangeLevelInverse(l)
+	if err != nil {
+		return nil
+	}
+	ms := h.combine(l, min, max)
+	buff, err := ms.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	ind, err := h.levels[0].best.Signature.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return &levelUpdate{
+		ids: h.sel.Select(l, h.c.CandidateCount),
+		p: &Packet{
+			Scheme:        h.scheme.ID(),
+			Session:       h.session,
+			Origin:        uint16(h.id.ID()),
+			Level:         byte(l),
+			MultiSig:      buff,
+			IndividualSig: ind,
+		},
+	}
+}
+
+// send sends out the given updates, unless the protocol is stopped. Errors are
+// ignored since the Network does not give any guarantees on the delivery of
+// the packets anyway.
+func (h *Handel) send(updates []*levelUpdate) {
+	for _, u := range updates {
+		for _, id := range u.ids {
+			select {
+			case <-h.done:
+				return
+			default:
+			}
+			h.net.Send(id, u.p)
+		}
+	}
+}
+
+// completeUpTo returns true if all levels up to the given one included are
+// complete. This method is NOT thread-safe and only meant for internal use.
+func (h *Handel) completeUpTo(l int) bool {
+	for i := 0; i <= l; i++ {
+		if !h.levels[i].complete() {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyLoop is run by each verification worker. Each time a multi-signature
+// is queued, it verifies the pending ones by order of score until none is
+// worth verifying anymore. If the signature scheme implements BatchVerifier,
+// up to BatchSize multi-signatures are verified at once. The verification
+// itself is done without holding the lock, and the valid multi-signatures are
+// then merged.
+func (h *Handel) verifyLoop() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-h.queued:
+		}
+		for {
+			h.Lock()
+			batch := h.popBatch()
+			h.Unlock()
+			if len(batch) == 0 {
+				break
+			}
+			for i, err := range h.verifyBatch(batch) {
+				sp := batch[i]
+				if err != nil {
+					h.blacklistOrigin(sp, err)
+					h.send(h.verifyIndividual(sp))
+					continue
+				}
+				h.Lock()
+				var updates []*levelUpdate
+				if !h.stopped && h.mergeSignature(h.levels[sp.level], sp.ms) {
+					updates = h.checkLevel()
+				}
+				h.Unlock()
+				h.send(updates)
+			}
+		}
+	}
+}
+
+// popBatch pops the multi-signatures to verify at once: up to BatchSize if
+// the signature scheme implements BatchVerifier, one otherwise. This method
+// is NOT thread-safe and only meant for internal use.
+func (h *Handel) popBatch() []*pendingSig {
+	size := 1
+	if _, ok := h.scheme.(BatchVerifier); ok {
+		size = h.c.BatchSize
+	}
+	var batch []*pendingSig
+	for len(batch) < size {
+		sp := h.queue.pop(h.levels)
+		if sp == nil {
+			break
+		}
+		batch = append(batch, sp)
+	}
+	return batch
+}
+
+// verifyBatch verifies the pending multi-signatures, at once if the signature
+// scheme implements BatchVerifier. It returns the verification error of each
+// multi-signature, nil for the valid ones.
+func (h *Handel) verifyBatch(batch []*pendingSig) []error {
+	errs := make([]error, len(batch))
+	bv, ok := h.scheme.(BatchVerifier)
+	if !ok || len(batch) == 1 {
+		for i, sp := range batch {
+			errs[i] = h.verifySignature(h.levels[sp.level], sp.ms)
+		}
+		return errs
+	}
+	var items []VerifyItem
+	var indexes []int
+	for i, sp := range batch {
+		pub, err := h.aggregatePublicKey(h.levels[sp.level], sp.ms)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		items = append(items, VerifyItem{Msg: h.msg, PublicKey: pub, Signature: sp.ms.Signature})
+		indexes = append(indexes, i)
+	}
+	for j, err := range BatchVerify(bv, items) {
+		errs[indexes[j]] = err
+	}
+	return errs
+}
+
+// verifyIndividual verifies the individual signature of the origin of the
+// pending multi-signature, if any, and adds it to the best multi-signature of
+// the level if it does not already contain the origin's contribution. It
+// returns the updates to send for the levels started.
+func (h *Handel) verifyIndividual(sp *pendingSig) []*levelUpdate {
+	if sp.ind == nil {
+		return nil
+	}
+	id, ok := h.reg.Identity(sp.origin)
+	if !ok || id.PublicKey().VerifySignature(h.msg, sp.ind) != nil {
+		return nil
+	}
+
 	h.Lock()
 	defer h.Unlock()
+	lvl := h.levels[sp.level]
+	idx := sp.origin - lvl.min
+	if h.stopped || (lvl.best != nil && lvl.best.Get(idx)) {
+		return nil
+	}
+	bs := h.c.NewBitSet(len(lvl.nodes))
+	bs.Set(idx, true)
+	sig := sp.ind
+	if lvl.best != nil {
+		for i := 0; i < lvl.best.BitLength(); i++ {
+			if lvl.best.Get(i) {
+				bs.Set(i, true)
+			}
+		}
+		sig = lvl.best.Signature.Combine(sig)
+	}
+	if !h.mergeSignature(lvl, &MultiSignature{BitSet: bs, Signature: sig}) {
+		return nil
+	}
+	return h.checkLevel()
+}
+
+// blacklistOrigin ignores all the future packets from the origin of the
+// invalid multi-signature and drops its pending multi-signatures. The origin
+// is reported to the application only if the packet was authenticated, since
+// anyone can forge the origin of a packet otherwise.
+func (h *Handel) blacklistOrigin(sp *pendingSig, err error) {
+	origin := sp.origin
+	h.Lock()
+	first := !h.blacklist[origin]
+	h.blacklist[origin] = true
+	h.queue.remove(origin)
+	h.early.remove(origin)
+	stopped := h.stopped
+	h.Unlock()
+	if !first || stopped || !sp.authenticated || h.c.OnBlacklist == nil {
+		return
+	}
+	if id, ok := h.reg.Identity(origin); ok {
+		h.c.OnBlacklist(id, err)
+	}
+}
+
+// verifySignature verifies the multi-signature received at the given level
+// against the combination of the public keys of the peers whose bits are set.
+func (h *Handel) verifySignature(lvl *level, ms *MultiSignature) error {
+	pub, err := h.aggregatePublicKey(lvl, ms)
+	if err != nil {
+		return err
+	}
+	return pub.VerifySignature(h.msg, ms.Signature)
+}
+
+// aggregatePublicKey returns the combination of the public keys of the peers
+// of the level whose bits are set in the multi-signature. It is computed from
+// the aggregate public keys of the subtrees of the level.
+func (h *Handel) aggregatePublicKey(lvl *level, ms *MultiSignature) (PublicKey, error) {
+	if lvl.keys == nil || ms.BitLength() != len(lvl.nodes) {
+		return nil, errors.New("handel: multi-signature does not span the level")
+	}
+	pub := lvl.keys.aggregate(ms.BitSet)
+	if pub == nil {
+		return nil, errors.New("handel: multi-signature without any contribution")
+	}
+	return pub, nil
+}
+
+// mergeSignature keeps the given verified multi-signature if it contains more
+// contributions than the best one of the level, and updates the aggregate
+// accordingly. It returns true if the multi-signature has been kept. This
+// method is NOT thread-safe and only meant for internal use.
+func (h *Handel) mergeSignature(lvl *level, ms *MultiSignature) bool {
+	if lvl.best != nil && lvl.best.Cardinality() >= ms.Cardinality() {
+		return false
+	}
+	lvl.best = ms
+	h.aggregate = h.combine(len(h.levels), 0, h.reg.Size())
+	h.output()
+	return true
+}
+
+// output sends the aggregate to the user if it contains enough contributions.
+// If the channel is full, the oldest multi-signature is dropped. This method is
+// NOT thread-safe and only meant for internal use.
+func (h *Handel) output() {
+	if h.stopped || h.aggregate.Cardinality() < h.c.ContributionsThreshold {
+		return
+	}
+	for {
+		select {
+		case h.out <- *h.aggregate:
+			return
+		default:
+		}
+		select {
+		case <-h.out:
+		default:
+		}
+	}
+}
+
+// combine returns the multi-signature combining the best multi-signatures of
+// the levels strictly below the given one. The returned bitset is relative to
+// the given range of IDs, which must include all the ranges of the levels
+// combined. This method is NOT thread-safe and only meant for internal use.
+func (h *Handel) combine(upTo, min, max int) *MultiSignature {
+	bs := h.c.NewBitSet(max - min)
+	var sig Signature
+	for _, lvl := range h.levels[:upTo] {
+		if lvl.best == nil {
+			continue
+		}
+		for i := 0; i < lvl.best.BitLength(); i++ {
+			if lvl.best.Get(i) {
+				bs.Set(lvl.min+i-min, true)
+			}
+		}
+		if sig == nil {
+			sig = lvl.best.Signature
+		} else {
+			sig = sig.Combine(lvl.best.Signature)
+		}
+	}
+	return &MultiSignature{BitSet: bs, Signature: sig}
 }
 
 // parsePacket returns the multisignature object held by the given packet, or an
 // error if the packet can't be unmarshalled, or contains erroneous data such as
-// an invalid signature or out of range origin. This method is NOT thread-safe
-// and only meant for internal use.
+// an out of range origin or level. It only reads fields that are immutable
+// after NewHandel, hence it is safe to call without holding the lock.
 func (h *Handel) parsePacket(p *Packet) (*MultiSignature, error) {
-	if p.Origin < 0 || p.Origin >= uint16(h.reg.Size()) {
+	if p.Scheme != h.scheme.ID() {
+		return nil, errors.New("handel: packet's signature scheme unknown")
+	}
+
+	if !bytes.Equal(p.Session, h.session) {
+		return nil, errors.New("handel: packet's session unknown")
+	}
+
+	if int(p.Origin) >= h.reg.Size() {
 		return nil, errors.New("handel: packet's origin out of range")
 	}
 
-	if p.Level < 0 || int(p.Level) > h.maxLevel() {
+	if p.Level == 0 || int(p.Level) > h.maxLevel() {
 		return nil, errors.New("handel: packet's level out of range")
 	}
 
+	lvl := h.levels[p.Level]
+	if int(p.Origin) < lvl.min || int(p.Origin) >= lvl.max {
+		return nil, errors.New("handel: packet's origin not in packet's level")
+	}
+
 	ms := new(MultiSignature)
-	err := ms.Unmarshal(p.MultiSig, h.scheme.Signature(), h.c.NewBitSet())
+	err := ms.Unmarshal(p.MultiSig, h.scheme.Signature(), h.c.NewBitSet(0))
 	if err != nil {
 		return nil, err
 	}
 
+	if ms.BitLength() != len(lvl.nodes) {
+		return nil, errors.New("handel: packet's bitset of invalid length")
+	}
+	if !ms.Get(int(p.Origin) - lvl.min) {
+		return nil, errors.New("handel: packet's multi-signature misses its origin's contribution")
+	}
 	return ms, err
 }
 
 func (h *Handel) maxLevel() int {
-	r := math.Log2(float64(h.reg.Size()))
-	return int(math.Ceil(r))
+	return h.part.MaxLevel()
 }
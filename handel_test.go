@@ -1,9 +1,306 @@
 package handel
 
-/*func TestHandelParsePacket(t *testing.T) {*/
-//n := 17
-//mlog := math.Log2(float64(h.reg.Size()))
-//log := int(math.Ceil(r))
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
 
-//sig := new(fakeSig)
-/*}*/
+	"github.com/stretchr/testify/require"
+)
+
+var msg = []byte("Sign me if you can")
+
+func testConfig() *Config {
+	return &Config{
+		ContributionsThreshold: 1,
+		LevelTimeout:           50 * time.Millisecond,
+		UpdatePeriod:           10 * time.Millisecond,
+	}
+}
+
+func newTestHandels(t *testing.T, n int) []*Handel {
+	reg := fakeRegistry(n)
+	net := newTestNetwork()
+	handels := make([]*Handel, n)
+	for i := 0; i < n; i++ {
+		id, _ := reg.Identity(i)
+		h, err := NewHandel(net.node(id.Address()), reg, id, new(fakeScheme), msg, testConfig())
+		require.NoError(t, err)
+		handels[i] = h
+	}
+	return handels
+}
+
+func TestHandelDefaultConfig(t *testing.T) {
+	reg := fakeRegistry(5)
+	id, _ := reg.Identity(2)
+	h, err := NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg)
+	require.NoError(t, err)
+	require.Equal(t, 3, h.c.ContributionsThreshold)
+
+	conf := testConfig()
+	conf.ContributionsThreshold = 6
+	_, err = NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg, conf)
+	require.Error(t, err)
+}
+
+// stampPacket sets the signature scheme and the session of the given Handel on
+// the packet.
+func stampPacket(h *Handel, p *Packet) *Packet {
+	p.Scheme = h.scheme.ID()
+	p.Session = h.session
+	return p
+}
+
+// newTestPacket returns the packet of the Handel instance holding the
+// multi-signature sent by the origin at the level.
+func newTestPacket(t *testing.T, h *Handel, origin, level int, ms *MultiSignature) *Packet {
+	buff, err := ms.MarshalBinary()
+	require.NoError(t, err)
+	return stampPacket(h, &Packet{Origin: uint16(origin), Level: byte(level), MultiSig: buff})
+}
+
+func TestHandelSession(t *testing.T) {
+	reg := fakeRegistry(4)
+	id, _ := reg.Identity(0)
+	h, err := NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg, testConfig())
+	require.NoError(t, err)
+	hash := sha256.Sum256(msg)
+	require.Equal(t, hash[:], h.session)
+
+	conf := testConfig()
+	conf.Session = []byte("session")
+	h, err = NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg, conf)
+	require.NoError(t, err)
+	require.Equal(t, conf.Session, h.session)
+}
+
+func TestHandelParsePacket(t *testing.T) {
+	n := 17
+	h := newTestHandels(t, n)[1]
+	ms := &MultiSignature{BitSet: NewWilffBitset(1), Signature: new(fakeSig)}
+	ms.Set(0, true)
+	valid, err := ms.MarshalBinary()
+	require.NoError(t, err)
+	ms.Set(0, false)
+	empty, err := ms.MarshalBinary()
+	require.NoError(t, err)
+
+	var tests = []struct {
+		p     *Packet
+		valid bool
+	}{
+		{&Packet{Origin: 0, Level: 1, MultiSig: valid}, true},
+		// other signature scheme
+		{&Packet{Scheme: 1, Session: h.session, Origin: 0, Level: 1, MultiSig: valid}, false},
+		// other session
+		{&Packet{Scheme: h.scheme.ID(), Session: []byte("other"), Origin: 0, Level: 1, MultiSig: valid}, false},
+		{&Packet{Origin: uint16(n), Level: 1, MultiSig: valid}, false},
+		{&Packet{Origin: 0, Level: 0, MultiSig: valid}, false},
+		{&Packet{Origin: 0, Level: byte(h.maxLevel() + 1), MultiSig: valid}, false},
+		// origin not in the range of level 2
+		{&Packet{Origin: 0, Level: 2, MultiSig: valid}, false},
+		// bitset of length 1 at level 2
+		{&Packet{Origin: 2, Level: 2, MultiSig: valid}, false},
+		{&Packet{Origin: 0, Level: 1, MultiSig: []byte{0x01}}, false},
+		// bitset without the origin's bit
+		{&Packet{Origin: 0, Level: 1, MultiSig: empty}, false},
+	}
+
+	for i, test := range tests {
+		if test.p.Session == nil {
+			stampPacket(h, test.p)
+		}
+		_, err := h.parsePacket(test.p)
+		if test.valid {
+			require.NoError(t, err, "test %d", i)
+		} else {
+			require.Error(t, err, "test %d", i)
+		}
+	}
+}
+
+func TestHandelPaddedBitset(t *testing.T) {
+	n := 4
+	handels := newTestHandels(t, n)
+	for _, h := range handels {
+		h.Start()
+	}
+	defer func() {
+		for _, h := range handels {
+			h.Stop()
+		}
+	}()
+
+	// level 2 of node 0 covers nodes 2 and 3: the bitset claims 64 of them
+	forged, err := encodeMultiSig(paddedBitset(t, 2), sig)
+	require.NoError(t, err)
+	p := stampPacket(handels[0], &Packet{Origin: 2, Level: 2, MultiSig: forged})
+	require.Error(t, handels[0].NewPacket(p))
+
+	require.Eventually(t, func() bool {
+		return handels[0].Aggregate().Cardinality() == n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandelAggregation(t *testing.T) {
+	for _, n := range []int{1, 2, 5, 16, 33} {
+		handels := newTestHandels(t, n)
+		for _, h := range handels {
+			h.Start()
+		}
+		for i, h := range handels {
+			require.Eventually(t, func() bool {
+				return h.Aggregate().Cardinality() == n
+			}, 5*time.Second, 10*time.Millisecond, "n=%d node %d", n, i)
+		}
+		for _, h := range handels {
+			h.Stop()
+		}
+	}
+}
+
+func TestHandelFinalSignatures(t *testing.T) {
+	n := 9
+	handels := newTestHandels(t, n)
+	for _, h := range handels {
+		h.c.ContributionsThreshold = n
+		h.Start()
+	}
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality(), "node %d", i)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("node %d did not output a multi-signature", i)
+		}
+	}
+	for _, h := range handels {
+		h.Stop()
+		h.Stop()
+		_, ok := <-h.FinalSignatures()
+		require.False(t, ok)
+	}
+}
+
+func TestHandelStartContext(t *testing.T) {
+	h := newTestHandels(t, 4)[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	h.StartContext(ctx)
+	cancel()
+	require.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return h.stopped
+	}, time.Second, 10*time.Millisecond)
+	// packets are ignored once stopped
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 1, 1, newTestMultiSig(1, 0))))
+	require.Equal(t, 0, h.queue.len())
+}
+
+func TestHandelBlacklist(t *testing.T) {
+	reg := fakeRegistry(4)
+	id, _ := reg.Identity(0)
+	reported := make(chan Identity, 2)
+	conf := testConfig()
+	conf.OnBlacklist = func(origin Identity, err error) {
+		require.Error(t, err)
+		reported <- origin
+	}
+	h, err := NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg, conf)
+	require.NoError(t, err)
+	h.Start()
+	defer h.Stop()
+
+	packet := func(bad bool) *Packet {
+		ms := newTestMultiSig(1, 0)
+		ms.Signature = &fakeSig{bad: bad}
+		p := newTestPacket(t, h, 1, 1, ms)
+		p.authenticated = true
+		return p
+	}
+
+	require.NoError(t, h.NewPacket(packet(true)))
+	select {
+	case origin := <-reported:
+		require.Equal(t, 1, origin.ID())
+	case <-time.After(time.Second):
+		t.Fatal("origin not reported")
+	}
+
+	// valid packets from the origin are now ignored
+	require.NoError(t, h.NewPacket(packet(false)))
+	require.NoError(t, h.NewPacket(packet(true)))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, h.Aggregate().Cardinality())
+	require.Len(t, reported, 0)
+
+	// an unauthenticated origin is blacklisted but not reported
+	ms := newTestMultiSig(2, 0)
+	ms.Signature = &fakeSig{bad: true}
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 2, 2, ms)))
+	require.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return h.blacklist[2]
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, reported, 0)
+
+	// nothing is reported once stopped
+	h.Stop()
+	h.blacklistOrigin(&pendingSig{origin: 3, authenticated: true}, errors.New("invalid"))
+	require.Len(t, reported, 0)
+}
+
+func TestHandelEarlyPackets(t *testing.T) {
+	reg := fakeRegistry(4)
+	id, _ := reg.Identity(0)
+	conf := testConfig()
+	conf.LevelTimeout = time.Hour
+	h, err := NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg, conf)
+	require.NoError(t, err)
+	defer h.Stop()
+
+	// packets received before Start, and for the level 2 not reached yet
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 2, 2, newTestMultiSig(2, 0))))
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 3, 2, newTestMultiSig(2, 0, 1))))
+	require.NoError(t, h.NewPacket(newTestPacket(t, h, 1, 1, newTestMultiSig(1, 0))))
+	require.Equal(t, 1, h.Aggregate().Cardinality())
+
+	h.Start()
+	require.Eventually(t, func() bool {
+		return h.Aggregate().Cardinality() == 4
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandelIndividualFallback(t *testing.T) {
+	reg := fakeRegistry(4)
+	id, _ := reg.Identity(0)
+	h, err := NewHandel(newTestNetwork().node(id.Address()), reg, id, new(fakeScheme), msg, testConfig())
+	require.NoError(t, err)
+	h.Start()
+	defer h.Stop()
+	require.Eventually(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return h.level == 2
+	}, time.Second, 10*time.Millisecond)
+
+	ms := newTestMultiSig(2, 0, 1)
+	ms.Signature = &fakeSig{bad: true}
+	buff, err := ms.MarshalBinary()
+	require.NoError(t, err)
+	ind, err := new(fakeSig).MarshalBinary()
+	require.NoError(t, err)
+	p := stampPacket(h, &Packet{Origin: 2, Level: 2, MultiSig: buff, IndividualSig: ind})
+	require.NoError(t, h.NewPacket(p))
+
+	// only the contribution of the origin is kept
+	require.Eventually(t, func() bool {
+		return h.Aggregate().Cardinality() == 2
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, h.Aggregate().Get(2))
+	require.False(t, h.Aggregate().Get(3))
+}
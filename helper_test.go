@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"strconv"
+	"sync"
 )
 
 type fakePublic struct{}
@@ -11,18 +13,54 @@ type fakePublic struct{}
 func (f *fakePublic) String() string {
 	return "fake public key"
 }
-func (f *fakePublic) VerifySignature([]byte, Signature) error {
+func (f *fakePublic) VerifySignature(msg []byte, s Signature) error {
+	if s.(*fakeSig).bad {
+		return errors.New("invalid fake signature")
+	}
 	return nil
 }
 func (f *fakePublic) Combine(PublicKey) PublicKey {
 	return f
 }
+func (f *fakePublic) Negate() PublicKey {
+	return f
+}
+func (f *fakePublic) VerifyPossession(proof Signature) error {
+	return f.VerifySignature(nil, proof)
+}
 
-type fakeIdentity struct{}
+type fakeIdentity struct {
+	id int
+}
 
-func (f *fakeIdentity) Address() string      { return "fake identity" }
+func (f *fakeIdentity) ID() int              { return f.id }
+func (f *fakeIdentity) Address() string      { return "fake-" + strconv.Itoa(f.id) }
 func (f *fakeIdentity) PublicKey() PublicKey { return new(fakePublic) }
 
+func fakeRegistry(n int) Registry {
+	return NewArrayRegistry(fakeIdentities(n))
+}
+
+func fakeIdentities(n int) []Identity {
+	ids := make([]Identity, n)
+	for i := 0; i < n; i++ {
+		ids[i] = &fakeIdentity{i}
+	}
+	return ids
+}
+
+type fakeScheme struct {
+	fakeSecret
+}
+
+func (f *fakeScheme) Signature() Signature {
+	return new(fakeSig)
+}
+
+func (f *fakeScheme) ID() byte {
+	return 0xff
+}
+
 type fakeSecret struct{}
 
 func (f *fakeSecret) PublicKey() PublicKey {
@@ -34,20 +72,88 @@ func (f *fakeSecret) Sign(msg []byte, rand io.Reader) (Signature, error) {
 }
 
 var sig = []byte{0x01, 0x02, 0x3, 0x04}
+var badSig = []byte{0x04, 0x03, 0x02, 0x01}
 
-type fakeSig struct{}
+// fakeSig is a signature that does not verify if it is bad or combined with a
+// bad one.
+type fakeSig struct {
+	bad bool
+}
 
 func (f *fakeSig) MarshalBinary() ([]byte, error) {
+	if f.bad {
+		return badSig, nil
+	}
 	return sig, nil
 }
 
 func (f *fakeSig) UnmarshalBinary(buff []byte) error {
-	if !bytes.Equal(buff, sig) {
+	switch {
+	case bytes.Equal(buff, sig):
+		f.bad = false
+	case bytes.Equal(buff, badSig):
+		f.bad = true
+	default:
 		return errors.New("invalid sig")
 	}
 	return nil
 }
 
-func (f *fakeSig) Combine(Signature) Signature {
-	return f
+func (f *fakeSig) Combine(s Signature) Signature {
+	return &fakeSig{bad: f.bad || s.(*fakeSig).bad}
+}
+
+// testNetwork dispatches packets to the Listeners registered under the
+// address of the destination identity.
+type testNetwork struct {
+	sync.Mutex
+	listeners map[string][]Listener
+}
+
+func newTestNetwork() *testNetwork {
+	return &testNetwork{listeners: make(map[string][]Listener)}
+}
+
+// nodeNetwork is the Network given to the Handel node at the given address.
+type nodeNetwork struct {
+	*testNetwork
+	addr string
+}
+
+func (t *testNetwork) node(addr string) Network {
+	return &nodeNetwork{t, addr}
+}
+
+func (n *nodeNetwork) RegisterListener(l Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listeners[n.addr] = append(n.listeners[n.addr], l)
+}
+
+func (n *nodeNetwork) Send(id Identity, p *Packet) error {
+	n.Lock()
+	defer n.Unlock()
+	for _, l := range n.listeners[id.Address()] {
+		go l.NewPacket(p)
+	}
+	return nil
+}
+
+// packetRecorder is a Listener recording the packets it receives.
+type packetRecorder struct {
+	sync.Mutex
+	packets []*Packet
+}
+
+func (r *packetRecorder) NewPacket(p *Packet) error {
+	r.Lock()
+	defer r.Unlock()
+	r.packets = append(r.packets, p)
+	return nil
+}
+
+func (r *packetRecorder) received() []*Packet {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Packet{}, r.packets...)
 }
@@ -2,11 +2,43 @@ package handel
 
 // Identity holds the public informations of a Handel node
 type Identity interface {
+	// ID returns the index of this node in the Registry. It is the position
+	// of the node's bit in the multi-signatures' bitsets.
+	ID() int
 	Address() string
 	// PublicKey returns the public key associated with that given node
 	PublicKey() PublicKey
 }
 
+// staticIdentity is an Identity whose fields are fixed at creation time
+type staticIdentity struct {
+	id   int
+	addr string
+	p    PublicKey
+}
+
+// NewStaticIdentity returns an Identity with the given ID, address and public
+// key.
+func NewStaticIdentity(id int, addr string, p PublicKey) Identity {
+	return &staticIdentity{
+		id:   id,
+		addr: addr,
+		p:    p,
+	}
+}
+
+func (s *staticIdentity) ID() int {
+	return s.id
+}
+
+func (s *staticIdentity) Address() string {
+	return s.addr
+}
+
+func (s *staticIdentity) PublicKey() PublicKey {
+	return s.p
+}
+
 // Registry abstracts the bookeeping of the list of Handel nodes
 type Registry interface {
 	// Size returns the total number of Handel nodes
@@ -0,0 +1,133 @@
+package handel
+
+// keyTree caches the aggregate public keys of the subtrees of the peers of a
+// level, following the binary tree of Handel. The public key of a
+// multi-signature's bitset is then computed by combining the keys of the whole
+// subtrees whose bits are all set, and by subtracting the public keys of the
+// missing peers from a subtree when it is cheaper than combining its parts.
+// Verifying a nearly complete multi-signature thus takes a few group
+// operations instead of one per contribution. A keyTree is immutable once
+// created, so it can be used concurrently.
+type keyTree struct {
+	// nodes of the tree, the root first
+	nodes []keyNode
+	// opposite of the public key of each peer, by index in the level
+	negs []PublicKey
+}
+
+// keyNode is a subtree of the level, covering the peers whose indexes are
+// between min inclusive and max exclusive.
+type keyNode struct {
+	min, max int
+	// aggregate public key of the subtree
+	pub PublicKey
+	// indexes of the children in the tree, -1 for the leaves
+	left, right int
+}
+
+// newKeyTree returns the keyTree of the given peers, or nil if there are none.
+func newKeyTree(ids []Identity) *keyTree {
+	if len(ids) == 0 {
+		return nil
+	}
+	t := &keyTree{negs: make([]PublicKey, len(ids))}
+	for i, id := range ids {
+		t.negs[i] = id.PublicKey().Negate()
+	}
+	t.build(ids, 0, len(ids))
+	return t
+}
+
+// build adds the node of the subtree covering the given range, and its
+// children, to the tree. It returns the index of the node.
+func (t *keyTree) build(ids []Identity, min, max int) int {
+	idx := len(t.nodes)
+	t.nodes = append(t.nodes, keyNode{min: min, max: max, left: -1, right: -1})
+	if max-min == 1 {
+		t.nodes[idx].pub = ids[min].PublicKey()
+		return idx
+	}
+	// the left child is the biggest power of two subtree, as in the Handel
+	// tree whose ranges are clamped to the size of the registry
+	half := 1
+	for half*2 < max-min {
+		half *= 2
+	}
+	left := t.build(ids, min, min+half)
+	right := t.build(ids, min+half, max)
+	t.nodes[idx].left = left
+	t.nodes[idx].right = right
+	t.nodes[idx].pub = t.nodes[left].pub.Combine(t.nodes[right].pub)
+	return idx
+}
+
+// aggregate returns the combination of the public keys of the peers whose
+// bits are set in the bitset, which spans the level. It returns nil if no bit
+// is set.
+func (t *keyTree) aggregate(bs BitSet) PublicKey {
+	costs := make([]int, len(t.nodes))
+	sets := make([]int, len(t.nodes))
+	t.plan(0, bs, costs, sets)
+	return t.combine(0, bs, costs, sets)
+}
+
+// plan computes, for the node and its children, the number of bits set and the
+// number of group operations needed to compute their public key.
+func (t *keyTree) plan(i int, bs BitSet, costs, sets []int) {
+	n := &t.nodes[i]
+	if n.left < 0 {
+		if bs.Get(n.min) {
+			sets[i] = 1
+		}
+		return
+	}
+	t.plan(n.left, bs, costs, sets)
+	t.plan(n.right, bs, costs, sets)
+	sets[i] = sets[n.left] + sets[n.right]
+	if sets[i] == 0 || sets[i] == n.max-n.min {
+		return
+	}
+	costs[i] = t.splitCost(n, costs, sets)
+	if missing := n.max - n.min - sets[i]; missing < costs[i] {
+		costs[i] = missing
+	}
+}
+
+// splitCost returns the number of group operations needed to compute the
+// public key of the node by combining the public keys of its children.
+func (t *keyTree) splitCost(n *keyNode, costs, sets []int) int {
+	cost := costs[n.left] + costs[n.right]
+	if sets[n.left] > 0 && sets[n.right] > 0 {
+		cost++
+	}
+	return cost
+}
+
+// combine computes the public key of the node following the plan.
+func (t *keyTree) combine(i int, bs BitSet, costs, sets []int) PublicKey {
+	n := &t.nodes[i]
+	switch {
+	case sets[i] == 0:
+		return nil
+	case sets[i] == n.max-n.min:
+		return n.pub
+	}
+	if missing := n.max - n.min - sets[i]; missing < t.splitCost(n, costs, sets) {
+		pub := n.pub
+		for j := n.min; j < n.max; j++ {
+			if !bs.Get(j) {
+				pub = pub.Combine(t.negs[j])
+			}
+		}
+		return pub
+	}
+	left := t.combine(n.left, bs, costs, sets)
+	right := t.combine(n.right, bs, costs, sets)
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	}
+	return left.Combine(right)
+}
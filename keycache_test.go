@@ -0,0 +1,78 @@
+package handel
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sumKey is a public key whose combination is the sum, counting the
+// combinations done.
+type sumKey struct {
+	v     int
+	count *int
+}
+
+func (s *sumKey) String() string                                  { return strconv.Itoa(s.v) }
+func (s *sumKey) VerifySignature(msg []byte, sig Signature) error { return nil }
+func (s *sumKey) Negate() PublicKey                               { return &sumKey{-s.v, s.count} }
+func (s *sumKey) Combine(p PublicKey) PublicKey {
+	*s.count++
+	return &sumKey{s.v + p.(*sumKey).v, s.count}
+}
+
+func TestKeyTree(t *testing.T) {
+	for _, n := range []int{1, 2, 5, 8, 13, 64} {
+		count := new(int)
+		ids := make([]Identity, n)
+		for i := range ids {
+			ids[i] = NewStaticIdentity(i, "", &sumKey{1 << uint(i), count})
+		}
+		tree := newKeyTree(ids)
+		r := rand.New(rand.NewSource(int64(n)))
+		for test := 0; test < 50; test++ {
+			bs := NewWilffBitset(n)
+			var expected int
+			for i := 0; i < n; i++ {
+				if r.Intn(2) == 0 {
+					bs.Set(i, true)
+					expected += 1 << uint(i)
+				}
+			}
+			pub := tree.aggregate(bs)
+			if expected == 0 {
+				require.Nil(t, pub, "n=%d", n)
+				continue
+			}
+			require.Equal(t, expected, pub.(*sumKey).v, "n=%d", n)
+		}
+	}
+	require.Nil(t, newKeyTree(nil))
+}
+
+func TestKeyTreeCost(t *testing.T) {
+	n := 64
+	count := new(int)
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(i, "", &sumKey{1, count})
+	}
+	tree := newKeyTree(ids)
+
+	bs := NewWilffBitset(n)
+	for i := 0; i < n; i++ {
+		bs.Set(i, true)
+	}
+	*count = 0
+	require.Equal(t, n, tree.aggregate(bs).(*sumKey).v)
+	require.Equal(t, 0, *count)
+
+	// a nearly complete bitset only costs the subtraction of the missing ones
+	bs.Set(3, false)
+	bs.Set(40, false)
+	*count = 0
+	require.Equal(t, n-2, tree.aggregate(bs).(*sumKey).v)
+	require.True(t, *count <= 2, "%d combinations", *count)
+}
@@ -0,0 +1,40 @@
+package handel
+
+// level holds the state of one level of the Handel tree, from the point of view
+// of a Handel node: the peers it exchanges multi-signatures with at this level
+// and the best multi-signature received from them so far. The level 0 only
+// contains the node itself.
+type level struct {
+	// id of the level in the tree
+	id int
+	// range of the IDs of the peers at this level, min inclusive and max
+	// exclusive
+	min, max int
+	// peers at this level
+	nodes []Identity
+	// aggregate public keys of the subtrees of the peers
+	keys *keyTree
+	// best verified multi-signature received from the peers at this level.
+	// Its bitset is relative to the range of the level.
+	best *MultiSignature
+}
+
+func newLevel(id, min, max int, nodes []Identity) *level {
+	return &level{
+		id:    id,
+		min:   min,
+		max:   max,
+		nodes: nodes,
+		keys:  newKeyTree(nodes),
+	}
+}
+
+// complete returns true if the best multi-signature of this level contains the
+// contributions of all the peers of this level. A level without any peers is
+// always complete.
+func (l *level) complete() bool {
+	if len(l.nodes) == 0 {
+		return true
+	}
+	return l.best != nil && l.best.Cardinality() == len(l.nodes)
+}
@@ -0,0 +1,24 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevelComplete(t *testing.T) {
+	reg := fakeRegistry(3)
+	nodes, _ := reg.Identities(0, 3)
+	l := newLevel(2, 0, 3, nodes)
+	require.False(t, l.complete())
+
+	bs := NewWilffBitset(3)
+	bs.Set(0, true)
+	bs.Set(2, true)
+	l.best = &MultiSignature{BitSet: bs, Signature: new(fakeSig)}
+	require.False(t, l.complete())
+	bs.Set(1, true)
+	require.True(t, l.complete())
+
+	require.True(t, newLevel(1, 3, 3, nil).complete())
+}
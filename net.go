@@ -3,6 +3,8 @@ package handel
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"math"
 )
 
 // Network is the interface that must be given to Handel to communicate with
@@ -24,32 +26,98 @@ type Listener interface {
 	NewPacket(*Packet) error
 }
 
+// WireVersion is the version of the binary encoding of the Packets and
+// MultiSignatures. Decoders reject encodings of any other version, so nodes
+// running incompatible versions of the protocol ignore each other's packets.
+const WireVersion byte = 1
+
+// ErrWireVersion is returned when decoding a Packet or a MultiSignature
+// encoded with another version than WireVersion.
+var ErrWireVersion = errors.New("handel: unknown wire version")
+
 // Packet is the general packet that Handel sends out and expects to receive
 // from the Network. Handel do not provide any authentication nor
 // confidentiality on Packets, it is up to the application layer to add these
-// features if relevant.
+// features if relevant. NewAuthNetwork and NewMACNetwork provide
+// authentication of the origin of the Packets.
 type Packet struct {
+	// Scheme is the identifier of the signature scheme of the multi-signature,
+	// as returned by SignatureScheme.ID.
+	Scheme byte
+	// Session identifies the Handel session, i.e. the message being signed,
+	// this packet belongs to.
+	Session []byte
 	// Origin is the ID of the sender of this packet.
 	Origin uint16
 	// Level indicates for which level this packet is for in the Handel tree.
 	Level byte
 	// MultiSig holds a MultiSignature struct.
 	MultiSig []byte
+	// IndividualSig optionally holds the individual signature of the origin.
+	// It allows to still use the contribution of the origin if its
+	// multi-signature turns out to be invalid.
+	IndividualSig []byte
+	// Envelope optionally holds data authenticating the rest of the packet,
+	// such as the signature added by the Network returned by NewAuthNetwork or
+	// the MAC added by the one returned by NewMACNetwork.
+	Envelope []byte
+
+	// authenticated is set once the Envelope has been verified by an
+	// authenticating Network. It is never encoded.
+	authenticated bool
 }
 
-// MarshalBinary implements the go BinaryMarshaler interface
+// MarshalBinary implements the go BinaryMarshaler interface. The encoding
+// starts with the WireVersion.
 func (p *Packet) MarshalBinary() ([]byte, error) {
+	if len(p.Session) > math.MaxUint8 {
+		return nil, errors.New("handel: packet's session too long")
+	}
 	var buffer bytes.Buffer
+	buffer.WriteByte(WireVersion)
+	buffer.WriteByte(p.Scheme)
+	buffer.WriteByte(byte(len(p.Session)))
+	buffer.Write(p.Session)
 	binary.Write(&buffer, binary.BigEndian, p.Origin)
 	binary.Write(&buffer, binary.BigEndian, p.Level)
-	buffer.Write(p.MultiSig)
+	if err := writeBytes(&buffer, p.MultiSig); err != nil {
+		return nil, err
+	}
+	if err := writeBytes(&buffer, p.IndividualSig); err != nil {
+		return nil, err
+	}
+	buffer.Write(p.Envelope)
 	return buffer.Bytes(), nil
 }
 
-// UnmarshalBinary implements the go BinaryUnmarshaler interface
+// UnmarshalBinary implements the go BinaryUnmarshaler interface. It returns
+// ErrWireVersion if the packet is encoded with another version than
+// WireVersion.
 func (p *Packet) UnmarshalBinary(buff []byte) error {
 	var buffer = bytes.NewBuffer(buff)
-	err := binary.Read(buffer, binary.BigEndian, &p.Origin)
+	version, err := buffer.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != WireVersion {
+		return ErrWireVersion
+	}
+	// the packet may be reused: no field is left from the previous one
+	*p = Packet{}
+	if p.Scheme, err = buffer.ReadByte(); err != nil {
+		return err
+	}
+	length, err := buffer.ReadByte()
+	if err != nil {
+		return err
+	}
+	if length > 0 {
+		p.Session = buffer.Next(int(length))
+		if len(p.Session) < int(length) {
+			return errors.New("handel: packet's session smaller than expected")
+		}
+	}
+	err = binary.Read(buffer, binary.BigEndian, &p.Origin)
 	if err != nil {
 		return err
 	}
@@ -57,6 +125,41 @@ func (p *Packet) UnmarshalBinary(buff []byte) error {
 	if err != nil {
 		return err
 	}
-	p.MultiSig = buffer.Bytes()
+	if p.MultiSig, err = readBytes(buffer); err != nil {
+		return err
+	}
+	if p.IndividualSig, err = readBytes(buffer); err != nil {
+		return err
+	}
+	if buffer.Len() > 0 {
+		p.Envelope = buffer.Bytes()
+	}
 	return nil
 }
+
+// writeBytes writes the length of the slice followed by the slice itself.
+func writeBytes(b *bytes.Buffer, buff []byte) error {
+	if len(buff) > math.MaxUint16 {
+		return errors.New("handel: packet's field too long")
+	}
+	binary.Write(b, binary.BigEndian, uint16(len(buff)))
+	b.Write(buff)
+	return nil
+}
+
+// readBytes reads a slice written by writeBytes. It returns nil for an empty
+// slice.
+func readBytes(b *bytes.Buffer) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(b, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	buff := b.Next(int(length))
+	if len(buff) < int(length) {
+		return nil, errors.New("handel: packet's field smaller than expected")
+	}
+	return buff, nil
+}
@@ -23,3 +23,47 @@ func TestPacketMarshalling(t *testing.T) {
 	require.Equal(t, p1.Origin, p2.Origin)
 	require.Equal(t, p1.MultiSig, p2.MultiSig)
 }
+
+func TestPacketMarshallingOptionalFields(t *testing.T) {
+	p1 := &Packet{
+		Scheme:        1,
+		Session:       []byte("session"),
+		Level:         2,
+		Origin:        3,
+		MultiSig:      []byte("what am I signing?"),
+		IndividualSig: []byte("me"),
+		Envelope:      []byte("sealed"),
+	}
+
+	buff, err := p1.MarshalBinary()
+	require.NoError(t, err)
+
+	p2 := new(Packet)
+	require.NoError(t, p2.UnmarshalBinary(buff))
+	require.Equal(t, p1, p2)
+
+	// a reused packet does not keep the fields of the previous one
+	buff, err = (&Packet{Level: 1, MultiSig: []byte("plain")}).MarshalBinary()
+	require.NoError(t, err)
+	p2.authenticated = true
+	require.NoError(t, p2.UnmarshalBinary(buff))
+	require.Equal(t, &Packet{Level: 1, MultiSig: []byte("plain")}, p2)
+
+	require.Error(t, new(Packet).UnmarshalBinary(buff[:6]))
+	require.Error(t, new(Packet).UnmarshalBinary(buff[:13]))
+	require.Error(t, new(Packet).UnmarshalBinary(buff[:len(buff)-9]))
+	_, err = (&Packet{MultiSig: make([]byte, 1<<16)}).MarshalBinary()
+	require.Error(t, err)
+	_, err = (&Packet{Session: make([]byte, 256)}).MarshalBinary()
+	require.Error(t, err)
+}
+
+func TestPacketWireVersion(t *testing.T) {
+	buff, err := (&Packet{Origin: 1, Level: 1}).MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, WireVersion, buff[0])
+
+	buff[0] = WireVersion + 1
+	require.Equal(t, ErrWireVersion, new(Packet).UnmarshalBinary(buff))
+	require.Error(t, new(Packet).UnmarshalBinary(nil))
+}
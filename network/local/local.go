@@ -0,0 +1,182 @@
+// Package local implements an in-memory Handel Network connecting many Handel
+// nodes running in the same process. It can simulate the latency, loss,
+// duplication and reordering of packets of a real network, and is mostly
+// useful for tests and simulations.
+package local
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// Config holds the behavior of the simulated network. The zero value delivers
+// every packet once, as fast as possible.
+type Config struct {
+	// Latency is the minimum delay before a packet is delivered.
+	Latency time.Duration
+	// Jitter is the maximum random delay added to the latency of each packet.
+	// A non-zero jitter reorders the packets.
+	Jitter time.Duration
+	// Loss is the probability, between 0 and 1, that a packet is dropped.
+	Loss float64
+	// Duplication is the probability, between 0 and 1, that a packet is
+	// delivered twice.
+	Duplication float64
+	// Seed is the seed of the randomness used to simulate the network.
+	Seed int64
+	// InboxSize is the number of packets each node can have waiting to be
+	// dispatched to its listeners. Packets arriving to a full inbox are
+	// dropped. If not specified, a size of 1000 is used by default.
+	InboxSize int
+}
+
+// DefaultInboxSize is the default size of the inbox of each node.
+const DefaultInboxSize = 1000
+
+// Hub routes the packets between the Networks of all the nodes. Each node
+// gets its own Network from the Hub, identified by the node's address.
+type Hub struct {
+	sync.Mutex
+	c     Config
+	rand  *rand.Rand
+	nodes map[string]*Network
+	done  chan struct{}
+}
+
+// NewHub returns a Hub simulating a network with the given config.
+func NewHub(c *Config) *Hub {
+	conf := *c
+	if conf.InboxSize == 0 {
+		conf.InboxSize = DefaultInboxSize
+	}
+	return &Hub{
+		c:     conf,
+		rand:  rand.New(rand.NewSource(conf.Seed)),
+		nodes: make(map[string]*Network),
+		done:  make(chan struct{}),
+	}
+}
+
+// Network returns the Network of the node with the given address, creating it
+// if necessary. The packets sent to an Identity whose Address is addr are
+// dispatched to the Listeners registered to this Network.
+func (h *Hub) Network(addr string) *Network {
+	h.Lock()
+	defer h.Unlock()
+	if n, ok := h.nodes[addr]; ok {
+		return n
+	}
+	n := &Network{
+		hub:   h,
+		addr:  addr,
+		inbox: make(chan *handel.Packet, h.c.InboxSize),
+	}
+	h.nodes[addr] = n
+	go n.dispatch()
+	return n
+}
+
+// Stop stops the delivery of all packets, including the ones in flight. Stop
+// can be called multiple times.
+func (h *Hub) Stop() {
+	h.Lock()
+	defer h.Unlock()
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+}
+
+// route simulates the transmission of the packet to the given node.
+func (h *Hub) route(to *Network, p *handel.Packet) {
+	h.Lock()
+	if h.rand.Float64() < h.c.Loss {
+		h.Unlock()
+		return
+	}
+	copies := 1
+	if h.rand.Float64() < h.c.Duplication {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = h.c.Latency
+		if h.c.Jitter > 0 {
+			delays[i] += time.Duration(h.rand.Int63n(int64(h.c.Jitter)))
+		}
+	}
+	h.Unlock()
+
+	for _, d := range delays {
+		if d == 0 {
+			to.deliver(p)
+			continue
+		}
+		time.AfterFunc(d, func() { to.deliver(p) })
+	}
+}
+
+// Network is the handel.Network of one node connected to a Hub.
+type Network struct {
+	sync.Mutex
+	hub       *Hub
+	addr      string
+	listeners []handel.Listener
+	inbox     chan *handel.Packet
+}
+
+// RegisterListener implements the handel.Network interface.
+func (n *Network) RegisterListener(l handel.Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listeners = append(n.listeners, l)
+}
+
+// Send implements the handel.Network interface. The packet is silently
+// dropped if the identity's address is not connected to the Hub.
+func (n *Network) Send(id handel.Identity, p *handel.Packet) error {
+	n.hub.Lock()
+	to, ok := n.hub.nodes[id.Address()]
+	n.hub.Unlock()
+	if !ok {
+		return nil
+	}
+	n.hub.route(to, p)
+	return nil
+}
+
+// Address returns the address of the node this Network belongs to.
+func (n *Network) Address() string {
+	return n.addr
+}
+
+// deliver puts the packet in the inbox, or drops it if the inbox is full.
+func (n *Network) deliver(p *handel.Packet) {
+	select {
+	case <-n.hub.done:
+	case n.inbox <- p:
+	default:
+	}
+}
+
+// dispatch gives the packets of the inbox to the listeners, until the Hub is
+// stopped.
+func (n *Network) dispatch() {
+	for {
+		select {
+		case <-n.hub.done:
+			return
+		case p := <-n.inbox:
+			n.Lock()
+			listeners := n.listeners
+			n.Unlock()
+			for _, l := range listeners {
+				l.NewPacket(p)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package local
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+// nodes is the number of Handel nodes run by TestNetworkHandel. Run it with
+// "go test ./network/local -nodes 1000" for a large scale run.
+var nodes = flag.Int("nodes", 100, "number of Handel nodes run over the local network")
+
+type fakePublic struct{}
+
+func (f *fakePublic) String() string                                 { return "fake public" }
+func (f *fakePublic) VerifySignature([]byte, handel.Signature) error { return nil }
+func (f *fakePublic) Combine(handel.PublicKey) handel.PublicKey      { return f }
+func (f *fakePublic) Negate() handel.PublicKey                       { return f }
+
+type fakeSig struct{}
+
+func (f *fakeSig) MarshalBinary() ([]byte, error) { return []byte{0x01}, nil }
+func (f *fakeSig) UnmarshalBinary(b []byte) error {
+	if len(b) != 1 || b[0] != 0x01 {
+		return errors.New("invalid fake signature")
+	}
+	return nil
+}
+func (f *fakeSig) Combine(handel.Signature) handel.Signature { return f }
+
+type fakeScheme struct{}
+
+func (f *fakeScheme) PublicKey() handel.PublicKey { return new(fakePublic) }
+func (f *fakeScheme) Sign([]byte, io.Reader) (handel.Signature, error) {
+	return new(fakeSig), nil
+}
+func (f *fakeScheme) Signature() handel.Signature { return new(fakeSig) }
+func (f *fakeScheme) ID() byte                    { return 0xff }
+
+// listener records the packets it receives.
+type listener struct {
+	sync.Mutex
+	packets []*handel.Packet
+}
+
+func (l *listener) NewPacket(p *handel.Packet) error {
+	l.Lock()
+	defer l.Unlock()
+	l.packets = append(l.packets, p)
+	return nil
+}
+
+func (l *listener) received() []*handel.Packet {
+	l.Lock()
+	defer l.Unlock()
+	return append([]*handel.Packet{}, l.packets...)
+}
+
+func newIdentities(n int) []handel.Identity {
+	ids := make([]handel.Identity, n)
+	for i := range ids {
+		ids[i] = handel.NewStaticIdentity(i, "node-"+strconv.Itoa(i), new(fakePublic))
+	}
+	return ids
+}
+
+func TestNetworkDelivery(t *testing.T) {
+	var tests = []struct {
+		c        Config
+		sent     int
+		expected int
+	}{
+		{Config{}, 10, 10},
+		{Config{Latency: 5 * time.Millisecond}, 10, 10},
+		{Config{Loss: 1}, 10, 0},
+		{Config{Duplication: 1}, 10, 20},
+	}
+
+	for i, test := range tests {
+		hub := NewHub(&test.c)
+		ids := newIdentities(2)
+		sender := hub.Network(ids[0].Address())
+		l1, l2 := new(listener), new(listener)
+		hub.Network(ids[1].Address()).RegisterListener(l1)
+		hub.Network(ids[1].Address()).RegisterListener(l2)
+		for j := 0; j < test.sent; j++ {
+			require.NoError(t, sender.Send(ids[1], &handel.Packet{Origin: uint16(j)}))
+		}
+		// unknown addresses are ignored
+		unknown := handel.NewStaticIdentity(3, "unknown", new(fakePublic))
+		require.NoError(t, sender.Send(unknown, &handel.Packet{}))
+
+		time.Sleep(50 * time.Millisecond)
+		require.Len(t, l1.received(), test.expected, "test %d", i)
+		require.Len(t, l2.received(), test.expected, "test %d", i)
+		hub.Stop()
+	}
+}
+
+func TestNetworkReordering(t *testing.T) {
+	hub := NewHub(&Config{Jitter: 20 * time.Millisecond, Seed: 42})
+	defer hub.Stop()
+	ids := newIdentities(2)
+	l := new(listener)
+	hub.Network(ids[1].Address()).RegisterListener(l)
+	n := 50
+	for i := 0; i < n; i++ {
+		hub.Network(ids[0].Address()).Send(ids[1], &handel.Packet{Origin: uint16(i)})
+	}
+	require.Eventually(t, func() bool {
+		return len(l.received()) == n
+	}, time.Second, 10*time.Millisecond)
+
+	inOrder := true
+	for i, p := range l.received() {
+		if int(p.Origin) != i {
+			inOrder = false
+		}
+	}
+	require.False(t, inOrder)
+}
+
+func TestNetworkHandel(t *testing.T) {
+	n := *nodes
+	hub := NewHub(&Config{
+		Latency:     time.Millisecond,
+		Jitter:      5 * time.Millisecond,
+		Loss:        0.05,
+		Duplication: 0.05,
+	})
+	defer hub.Stop()
+
+	ids := newIdentities(n)
+	reg := handel.NewArrayRegistry(ids)
+	handels := make([]*handel.Handel, n)
+	for i, id := range ids {
+		conf := &handel.Config{
+			ContributionsThreshold: n,
+			LevelTimeout:           100 * time.Millisecond,
+			UpdatePeriod:           50 * time.Millisecond,
+			CandidateCount:         2,
+			VerifierCount:          1,
+		}
+		h, err := handel.NewHandel(hub.Network(id.Address()), reg, id, new(fakeScheme), []byte("hello"), conf)
+		require.NoError(t, err)
+		handels[i] = h
+	}
+	for _, h := range handels {
+		h.Start()
+		defer h.Stop()
+	}
+	// all the nodes run concurrently: they share one deadline
+	deadline := time.After(30*time.Second + time.Duration(n)*100*time.Millisecond)
+	for i, h := range handels {
+		select {
+		case ms := <-h.FinalSignatures():
+			require.Equal(t, n, ms.Cardinality())
+		case <-deadline:
+			t.Fatalf("node %d did not output the full multi-signature", i)
+		}
+	}
+}
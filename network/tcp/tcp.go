@@ -0,0 +1,396 @@
+// Package tcp implements a Handel Network over TCP. Packets are sent as
+// frames made of the length of the binary encoding of the Packet followed by
+// the encoding itself. Connections to peers are created lazily, re-established
+// with an exponential backoff when they fail, and closed when idle. Packets to
+// a peer that can't be reached are dropped. Each peer has its own bounded
+// queue of packets so a slow peer does not block the others. The address of
+// each Identity must be a "host:port" string.
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// ErrQueueFull is returned by Send when too many packets are waiting to be sent
+// to the peer.
+var ErrQueueFull = errors.New("tcp: send queue of the peer is full")
+
+// ErrFrameTooLarge is returned by Send when the marshalled packet is larger
+// than the maximum frame size.
+var ErrFrameTooLarge = errors.New("tcp: packet larger than the maximum frame size")
+
+// ErrStopped is returned by Send when the Network is stopped.
+var ErrStopped = errors.New("tcp: network stopped")
+
+// Config holds the parameters of the TCP Network.
+type Config struct {
+	// QueueSize is the maximum number of packets waiting to be sent to each
+	// peer. If not specified, 100 is used by default.
+	QueueSize int
+	// DialTimeout is the timeout to establish a connection to a peer. If not
+	// specified, 5s is used by default.
+	DialTimeout time.Duration
+	// WriteTimeout is the timeout to write a packet to a peer, after which the
+	// connection is considered failed. If not specified, 5s is used by
+	// default.
+	WriteTimeout time.Duration
+	// MinBackoff is the delay before trying to connect again to a peer after
+	// a first failure. The delay doubles after each failure up to
+	// MaxBackoff. If not specified, 50ms is used by default.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between two connection attempts. If not
+	// specified, 5s is used by default.
+	MaxBackoff time.Duration
+	// IdleTimeout is the duration after which a connection to a peer is
+	// closed if no packets have been sent to it. If not specified, 1min is
+	// used by default.
+	IdleTimeout time.Duration
+	// MaxFrameSize is the maximum size of the frames sent and received. If not
+	// specified, 1MB is used by default.
+	MaxFrameSize int
+}
+
+// DefaultConfig returns the default configuration of the TCP Network.
+func DefaultConfig() *Config {
+	return &Config{
+		QueueSize:    100,
+		DialTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		MinBackoff:   50 * time.Millisecond,
+		MaxBackoff:   5 * time.Second,
+		IdleTimeout:  time.Minute,
+		MaxFrameSize: 1 << 20,
+	}
+}
+
+func mergeWithDefault(c *Config) *Config {
+	c2 := *c
+	d := DefaultConfig()
+	if c2.QueueSize == 0 {
+		c2.QueueSize = d.QueueSize
+	}
+	if c2.DialTimeout == 0 {
+		c2.DialTimeout = d.DialTimeout
+	}
+	if c2.WriteTimeout == 0 {
+		c2.WriteTimeout = d.WriteTimeout
+	}
+	if c2.MinBackoff == 0 {
+		c2.MinBackoff = d.MinBackoff
+	}
+	if c2.MaxBackoff == 0 {
+		c2.MaxBackoff = d.MaxBackoff
+	}
+	if c2.IdleTimeout == 0 {
+		c2.IdleTimeout = d.IdleTimeout
+	}
+	if c2.MaxFrameSize == 0 {
+		c2.MaxFrameSize = d.MaxFrameSize
+	}
+	return &c2
+}
+
+// Network is a handel.Network sending and receiving Packets over TCP.
+type Network struct {
+	sync.Mutex
+	c         *Config
+	ln        net.Listener
+	listeners []handel.Listener
+	// pool of outgoing connections, keyed by address
+	peers map[string]*peer
+	// incoming connections
+	conns map[net.Conn]bool
+	done  chan struct{}
+	// cancels the dials in progress when the Network is stopped
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewNetwork listens on the given "host:port" address and returns a Network
+// using it. The first config in the slice is taken if not nil. Otherwise, the
+// default config generated by DefaultConfig() is used.
+func NewNetwork(listen string, conf ...*Config) (*Network, error) {
+	c := DefaultConfig()
+	if len(conf) > 0 && conf[0] != nil {
+		c = mergeWithDefault(conf[0])
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Network{
+		c:      c,
+		ln:     ln,
+		peers:  make(map[string]*peer),
+		conns:  make(map[net.Conn]bool),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	n.wg.Add(1)
+	go n.accept()
+	return n, nil
+}
+
+// RegisterListener implements the handel.Network interface.
+func (n *Network) RegisterListener(l handel.Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listeners = append(n.listeners, l)
+}
+
+// Send implements the handel.Network interface. The packet is queued to be
+// sent by the connection to the peer, which is created if necessary. Send
+// never blocks: it returns ErrQueueFull if the queue of the peer is full.
+func (n *Network) Send(id handel.Identity, p *handel.Packet) error {
+	buff, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(buff) > n.c.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	select {
+	case <-n.done:
+		return ErrStopped
+	default:
+	}
+	pe, ok := n.peers[id.Address()]
+	if !ok {
+		pe = &peer{
+			n:     n,
+			addr:  id.Address(),
+			queue: make(chan []byte, n.c.QueueSize),
+		}
+		n.peers[pe.addr] = pe
+		n.wg.Add(1)
+		go pe.run()
+	}
+	select {
+	case pe.queue <- buff:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Addr returns the local address the Network listens on.
+func (n *Network) Addr() net.Addr {
+	return n.ln.Addr()
+}
+
+// Stop closes all connections and stops listening. The Network can't be used
+// afterwards.
+func (n *Network) Stop() error {
+	n.Lock()
+	select {
+	case <-n.done:
+		n.Unlock()
+		return nil
+	default:
+	}
+	close(n.done)
+	n.cancel()
+	err := n.ln.Close()
+	for c := range n.conns {
+		c.Close()
+	}
+	n.Unlock()
+	n.wg.Wait()
+	return err
+}
+
+// accept accepts incoming connections until the Network is stopped. After an
+// error, it backs off exponentially before accepting again.
+func (n *Network) accept() {
+	defer n.wg.Done()
+	var backoff time.Duration
+	for {
+		c, err := n.ln.Accept()
+		if err != nil {
+			if backoff == 0 {
+				backoff = n.c.MinBackoff
+			}
+			select {
+			case <-n.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > n.c.MaxBackoff {
+				backoff = n.c.MaxBackoff
+			}
+			continue
+		}
+		backoff = 0
+		n.Lock()
+		select {
+		case <-n.done:
+			n.Unlock()
+			c.Close()
+			return
+		default:
+		}
+		n.conns[c] = true
+		n.wg.Add(1)
+		n.Unlock()
+		go n.read(c)
+	}
+}
+
+// read reads the frames from the incoming connection and dispatches the
+// packets to the listeners, until the connection fails or carries an invalid
+// frame.
+func (n *Network) read(c net.Conn) {
+	defer n.wg.Done()
+	defer func() {
+		n.Lock()
+		delete(n.conns, c)
+		n.Unlock()
+		c.Close()
+	}()
+	r := bufio.NewReader(c)
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return
+		}
+		if int(length) > n.c.MaxFrameSize {
+			return
+		}
+		buff := make([]byte, length)
+		if _, err := io.ReadFull(r, buff); err != nil {
+			return
+		}
+		p := new(handel.Packet)
+		if err := p.UnmarshalBinary(buff); err != nil {
+			continue
+		}
+		n.Lock()
+		listeners := n.listeners
+		n.Unlock()
+		for _, l := range listeners {
+			l.NewPacket(p)
+		}
+	}
+}
+
+// peer holds the outgoing connection to one peer and its queue of packets.
+type peer struct {
+	n     *Network
+	addr  string
+	queue chan []byte
+	conn  net.Conn
+	// delay to wait after the next failed dial, zero after a successful one
+	backoff time.Duration
+	// no dial is attempted before this time
+	retry time.Time
+}
+
+// run sends the queued packets to the peer, connecting to it when necessary.
+// It closes the connection and removes the peer from the pool once the
+// connection has been idle for IdleTimeout.
+func (p *peer) run() {
+	defer p.n.wg.Done()
+	defer p.close()
+	idle := time.NewTimer(p.n.c.IdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-p.n.done:
+			return
+		case <-idle.C:
+			if p.evict() {
+				return
+			}
+			idle.Reset(p.n.c.IdleTimeout)
+		case buff := <-p.queue:
+			p.send(buff)
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(p.n.c.IdleTimeout)
+		}
+	}
+}
+
+// evict removes the peer from the pool if no packets are waiting to be sent.
+func (p *peer) evict() bool {
+	p.n.Lock()
+	defer p.n.Unlock()
+	if len(p.queue) > 0 {
+		return false
+	}
+	delete(p.n.peers, p.addr)
+	return true
+}
+
+// send writes the frame to the peer. If the connection fails, it reconnects
+// and tries once more before dropping the packet. Packets are dropped as well
+// while the peer can't be reached.
+func (p *peer) send(buff []byte) {
+	frame := make([]byte, 4+len(buff))
+	binary.BigEndian.PutUint32(frame, uint32(len(buff)))
+	copy(frame[4:], buff)
+	for i := 0; i < 2; i++ {
+		if !p.connect() {
+			return
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(p.n.c.WriteTimeout))
+		if _, err := p.conn.Write(frame); err == nil {
+			return
+		}
+		p.close()
+	}
+}
+
+// connect dials the peer if there is no connection yet. After a failed dial,
+// no other dial is attempted before an exponential backoff has elapsed. It
+// returns false if there is no connection to the peer. The dial is aborted
+// when the Network is stopped.
+func (p *peer) connect() bool {
+	if p.conn != nil {
+		return true
+	}
+	now := time.Now()
+	if now.Before(p.retry) {
+		return false
+	}
+	d := net.Dialer{Timeout: p.n.c.DialTimeout}
+	c, err := d.DialContext(p.n.ctx, "tcp", p.addr)
+	if err != nil {
+		if p.backoff == 0 {
+			p.backoff = p.n.c.MinBackoff
+		}
+		p.retry = now.Add(p.backoff)
+		p.backoff *= 2
+		if p.backoff > p.n.c.MaxBackoff {
+			p.backoff = p.n.c.MaxBackoff
+		}
+		return false
+	}
+	p.conn = c
+	p.backoff = 0
+	return true
+}
+
+func (p *peer) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
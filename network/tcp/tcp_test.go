@@ -0,0 +1,125 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+type listener struct {
+	sync.Mutex
+	packets []*handel.Packet
+}
+
+func (l *listener) NewPacket(p *handel.Packet) error {
+	l.Lock()
+	defer l.Unlock()
+	l.packets = append(l.packets, p)
+	return nil
+}
+
+func (l *listener) received() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.packets)
+}
+
+func identity(addr string) handel.Identity {
+	return handel.NewStaticIdentity(0, addr, nil)
+}
+
+// freeAddr returns a local address nobody listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	return addr
+}
+
+func TestTCPNetwork(t *testing.T) {
+	n1, err := NewNetwork("127.0.0.1:0")
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:0", &Config{
+		IdleTimeout:  100 * time.Millisecond,
+		MaxFrameSize: 1000,
+	})
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	l1, l2 := new(listener), new(listener)
+	n1.RegisterListener(l1)
+	n2.RegisterListener(l2)
+
+	p := &handel.Packet{Origin: 1, Level: 2, MultiSig: []byte("multisig")}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, n1.Send(identity(n2.Addr().String()), p))
+		require.NoError(t, n2.Send(identity(n1.Addr().String()), p))
+	}
+	require.Eventually(t, func() bool {
+		return l1.received() == 10 && l2.received() == 10
+	}, time.Second, 10*time.Millisecond)
+	l2.Lock()
+	require.Equal(t, p, l2.packets[0])
+	l2.Unlock()
+
+	// the idle connection of n2 is evicted, and created again when needed
+	require.Eventually(t, func() bool {
+		n2.Lock()
+		defer n2.Unlock()
+		return len(n2.peers) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, n2.Send(identity(n1.Addr().String()), p))
+	require.Eventually(t, func() bool {
+		return l1.received() == 11
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, ErrFrameTooLarge, n2.Send(identity(n1.Addr().String()), &handel.Packet{MultiSig: make([]byte, 2000)}))
+}
+
+func TestTCPNetworkReconnect(t *testing.T) {
+	addr := freeAddr(t)
+	n1, err := NewNetwork("127.0.0.1:0", &Config{
+		QueueSize:   2,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  20 * time.Millisecond,
+		IdleTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer n1.Stop()
+
+	// packets are dropped while the peer can't be reached, so the queue
+	// drains and the idle peer is evicted
+	p := &handel.Packet{Origin: 1, Level: 2, MultiSig: []byte("multisig")}
+	for i := 0; i < 5; i++ {
+		n1.Send(identity(addr), p)
+	}
+	require.Eventually(t, func() bool {
+		n1.Lock()
+		defer n1.Unlock()
+		return len(n1.peers) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the peer comes up later on
+	n2, err := NewNetwork(addr)
+	require.NoError(t, err)
+	defer n2.Stop()
+	l := new(listener)
+	n2.RegisterListener(l)
+	require.Eventually(t, func() bool {
+		n1.Send(identity(addr), p)
+		return l.received() > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, n1.Stop())
+	require.NoError(t, n1.Stop())
+	require.Equal(t, ErrStopped, n1.Send(identity(addr), p))
+	// the dials in progress are aborted
+	require.Equal(t, context.Canceled, n1.ctx.Err())
+}
@@ -0,0 +1,173 @@
+// Package udp implements a Handel Network over UDP. Each Packet is sent in its
+// own datagram, using the binary encoding of the Packet. The address of each
+// Identity must be a "host:port" string.
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/handel"
+)
+
+// MaxDatagramSize is the maximum payload size of an UDP datagram over IPv4.
+const MaxDatagramSize = 65507
+
+// readErrorDelay is the delay before reading again after a read error. It
+// doubles after each consecutive error, up to maxReadErrorDelay.
+const readErrorDelay = 10 * time.Millisecond
+
+const maxReadErrorDelay = time.Second
+
+// ErrPacketTooLarge is returned by Send when a marshalled Packet does not fit
+// in a datagram, usually because its multi-signature is too large.
+var ErrPacketTooLarge = errors.New("udp: packet too large for a datagram")
+
+// Config holds the parameters of the UDP Network.
+type Config struct {
+	// MaxDatagramSize is the maximum size of the datagrams sent and received.
+	// If not specified, MaxDatagramSize is used by default.
+	MaxDatagramSize int
+}
+
+// Network is a handel.Network sending and receiving Packets over UDP.
+type Network struct {
+	sync.RWMutex
+	conn      *net.UDPConn
+	maxSize   int
+	listeners []handel.Listener
+	addrs     map[string]*net.UDPAddr
+	done      chan struct{}
+}
+
+// NewNetwork binds an UDP socket to the given "host:port" address and returns
+// a Network using it. The first config in the slice is taken if not nil.
+func NewNetwork(listen string, conf ...*Config) (*Network, error) {
+	maxSize := MaxDatagramSize
+	if len(conf) > 0 && conf[0] != nil && conf[0].MaxDatagramSize > 0 {
+		maxSize = conf[0].MaxDatagramSize
+	}
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	n := &Network{
+		conn:    conn,
+		maxSize: maxSize,
+		addrs:   make(map[string]*net.UDPAddr),
+		done:    make(chan struct{}),
+	}
+	go n.listen()
+	return n, nil
+}
+
+// RegisterListener implements the handel.Network interface.
+func (n *Network) RegisterListener(l handel.Listener) {
+	n.Lock()
+	defer n.Unlock()
+	n.listeners = append(n.listeners, l)
+}
+
+// Send implements the handel.Network interface. It returns an error wrapping
+// ErrPacketTooLarge if the marshalled packet does not fit in a datagram.
+func (n *Network) Send(id handel.Identity, p *handel.Packet) error {
+	buff, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(buff) > n.maxSize {
+		return fmt.Errorf("%w: %d bytes with a multi-signature of %d bytes, max %d",
+			ErrPacketTooLarge, len(buff), len(p.MultiSig), n.maxSize)
+	}
+	addr, err := n.resolve(id.Address())
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(buff, addr)
+	return err
+}
+
+// Addr returns the local address the socket is bound to.
+func (n *Network) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// Stop closes the socket. The Network can't be used afterwards.
+func (n *Network) Stop() error {
+	n.Lock()
+	select {
+	case <-n.done:
+		n.Unlock()
+		return nil
+	default:
+		close(n.done)
+	}
+	n.Unlock()
+	return n.conn.Close()
+}
+
+// resolve returns the UDP address of the given "host:port" address, caching
+// the resolutions.
+func (n *Network) resolve(address string) (*net.UDPAddr, error) {
+	n.RLock()
+	addr, ok := n.addrs[address]
+	n.RUnlock()
+	if ok {
+		return addr, nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	n.Lock()
+	n.addrs[address] = addr
+	n.Unlock()
+	return addr, nil
+}
+
+// listen reads the incoming datagrams and dispatches the packets to the
+// listeners until the Network is stopped. Datagrams which are not valid
+// packets are dropped. After a read error, it backs off before reading again.
+func (n *Network) listen() {
+	buff := make([]byte, n.maxSize)
+	var delay time.Duration
+	for {
+		size, _, err := n.conn.ReadFromUDP(buff)
+		if err != nil {
+			if delay == 0 {
+				delay = readErrorDelay
+			}
+			select {
+			case <-n.done:
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > maxReadErrorDelay {
+				delay = maxReadErrorDelay
+			}
+			continue
+		}
+		delay = 0
+		// the packet keeps references to the buffer it is unmarshalled from
+		datagram := make([]byte, size)
+		copy(datagram, buff[:size])
+		p := new(handel.Packet)
+		if err := p.UnmarshalBinary(datagram); err != nil {
+			continue
+		}
+		n.RLock()
+		listeners := n.listeners
+		n.RUnlock()
+		for _, l := range listeners {
+			l.NewPacket(p)
+		}
+	}
+}
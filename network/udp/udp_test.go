@@ -0,0 +1,75 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/handel"
+	"github.com/stretchr/testify/require"
+)
+
+type listener struct {
+	sync.Mutex
+	packets []*handel.Packet
+}
+
+func (l *listener) NewPacket(p *handel.Packet) error {
+	l.Lock()
+	defer l.Unlock()
+	l.packets = append(l.packets, p)
+	return nil
+}
+
+func (l *listener) received() []*handel.Packet {
+	l.Lock()
+	defer l.Unlock()
+	return append([]*handel.Packet{}, l.packets...)
+}
+
+func TestUDPNetwork(t *testing.T) {
+	n1, err := NewNetwork("127.0.0.1:0")
+	require.NoError(t, err)
+	defer n1.Stop()
+	n2, err := NewNetwork("127.0.0.1:0", &Config{MaxDatagramSize: 100})
+	require.NoError(t, err)
+	defer n2.Stop()
+
+	l1, l2 := new(listener), new(listener)
+	n2.RegisterListener(l1)
+	n2.RegisterListener(l2)
+
+	id2 := handel.NewStaticIdentity(2, n2.Addr().String(), nil)
+	p := &handel.Packet{
+		Origin:        1,
+		Level:         3,
+		MultiSig:      []byte("multisig"),
+		IndividualSig: []byte("sig"),
+	}
+	require.NoError(t, n1.Send(id2, p))
+	for _, l := range []*listener{l1, l2} {
+		require.Eventually(t, func() bool {
+			return len(l.received()) == 1
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, p, l.received()[0])
+	}
+
+	// datagrams which are not packets are dropped
+	_, err = n1.conn.WriteToUDP([]byte{0x01}, n2.conn.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+
+	// n2 can't send packets larger than 100 bytes
+	id1 := handel.NewStaticIdentity(1, n1.Addr().String(), nil)
+	err = n2.Send(id1, &handel.Packet{MultiSig: make([]byte, 100)})
+	require.True(t, errors.Is(err, ErrPacketTooLarge))
+	require.NoError(t, n2.Send(id1, &handel.Packet{MultiSig: make([]byte, 50)}))
+
+	require.Error(t, n1.Send(handel.NewStaticIdentity(3, "not an address", nil), p))
+
+	require.NoError(t, n2.Stop())
+	require.NoError(t, n2.Stop())
+	time.Sleep(20 * time.Millisecond)
+	require.Len(t, l1.received(), 1)
+}
@@ -0,0 +1,138 @@
+package handel
+
+import (
+	"errors"
+	"math"
+)
+
+// Partitioner splits the IDs of the Registry into a binary tree from the point
+// of view of one Handel node. At level l, the node exchanges multi-signatures
+// with the peers of the sibling subtree of size 2^(l-1) of the subtree
+// containing its own ID. The level 0 only contains the node itself.
+type Partitioner interface {
+	// MaxLevel returns the highest level of the tree.
+	MaxLevel() int
+	// RangeLevel returns the range of IDs of the peers at the given level,
+	// min inclusive and max exclusive. The range is empty if the level has no
+	// peers, which happens when the size of the registry is not a power of
+	// two.
+	RangeLevel(level int) (min, max int, err error)
+	// RangeLevelInverse returns the range of IDs of the subtree containing the
+	// node's own ID at the given level, min inclusive and max exclusive. It is
+	// the range covered by the multi-signature the node sends out to the peers
+	// of that level.
+	RangeLevelInverse(level int) (min, max int, err error)
+	// IdentitiesAt returns the identities of the peers at the given level.
+	IdentitiesAt(level int) ([]Identity, error)
+	// LevelBitSet maps a bitset spanning the whole registry to a bitset
+	// spanning only the peers of the given level.
+	LevelBitSet(level int, global BitSet) (BitSet, error)
+	// GlobalBitSet maps a bitset spanning the peers of the given level to a
+	// bitset spanning the whole registry.
+	GlobalBitSet(level int, local BitSet) (BitSet, error)
+}
+
+// binTreePartition is a Partitioner that splits the IDs in a binary tree
+// following the order of the IDs in the registry.
+type binTreePartition struct {
+	id        int
+	reg       Registry
+	size      int
+	maxLevel  int
+	newBitSet func(int) BitSet
+}
+
+// NewBinPartitioner returns a Partitioner splitting the registry in a binary
+// tree from the point of view of the given ID. The given function is used to
+// create the bitsets mapped to the whole registry.
+func NewBinPartitioner(id int, reg Registry, newBitSet func(int) BitSet) Partitioner {
+	return &binTreePartition{
+		id:        id,
+		reg:       reg,
+		size:      reg.Size(),
+		maxLevel:  int(math.Ceil(math.Log2(float64(reg.Size())))),
+		newBitSet: newBitSet,
+	}
+}
+
+func (b *binTreePartition) MaxLevel() int {
+	return b.maxLevel
+}
+
+func (b *binTreePartition) RangeLevel(level int) (int, int, error) {
+	if level < 0 || level > b.maxLevel {
+		return 0, 0, errors.New("handel: partitioner level out of range")
+	}
+	if level == 0 {
+		return b.id, b.id + 1, nil
+	}
+	half := 1 << uint(level-1)
+	start := b.id - b.id%(2*half)
+	min := start
+	if b.id-start < half {
+		min = start + half
+	}
+	min, max := b.clamp(min, min+half)
+	return min, max, nil
+}
+
+func (b *binTreePartition) RangeLevelInverse(level int) (int, int, error) {
+	if level < 0 || level > b.maxLevel {
+		return 0, 0, errors.New("handel: partitioner level out of range")
+	}
+	if level == 0 {
+		return b.id, b.id + 1, nil
+	}
+	half := 1 << uint(level-1)
+	min := b.id - b.id%half
+	min, max := b.clamp(min, min+half)
+	return min, max, nil
+}
+
+func (b *binTreePartition) IdentitiesAt(level int) ([]Identity, error) {
+	min, max, err := b.RangeLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	ids, ok := b.reg.Identities(min, max)
+	if !ok {
+		return nil, errors.New("handel: registry can't give identities of the level")
+	}
+	return ids, nil
+}
+
+func (b *binTreePartition) LevelBitSet(level int, global BitSet) (BitSet, error) {
+	min, max, err := b.RangeLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if global.BitLength() != b.size {
+		return nil, errors.New("handel: bitset does not span the registry")
+	}
+	return global.Slice(min, max), nil
+}
+
+func (b *binTreePartition) GlobalBitSet(level int, local BitSet) (BitSet, error) {
+	min, max, err := b.RangeLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if local.BitLength() != max-min {
+		return nil, errors.New("handel: bitset does not span the level")
+	}
+	before := b.newBitSet(min)
+	after := b.newBitSet(b.size - max)
+	return before.Combine(local).Combine(after), nil
+}
+
+// clamp truncates the range to the size of the registry, returning an empty
+// range if it is entirely out of the registry.
+func (b *binTreePartition) clamp(min, max int) (int, int) {
+	if max > b.size {
+		max = b.size
+	}
+	if min > max {
+		min = max
+	}
+	return min, max
+}
@@ -0,0 +1,88 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionerRanges(t *testing.T) {
+	var tests = []struct {
+		id, level, size  int
+		peerMin, peerMax int
+		ownMin, ownMax   int
+	}{
+		{0, 0, 8, 0, 1, 0, 1},
+		{0, 1, 8, 1, 2, 0, 1},
+		{0, 2, 8, 2, 4, 0, 2},
+		{0, 3, 8, 4, 8, 0, 4},
+		{5, 1, 8, 4, 5, 5, 6},
+		{5, 2, 8, 6, 8, 4, 6},
+		{5, 3, 8, 0, 4, 4, 8},
+		// sizes that are not powers of two
+		{4, 1, 5, 5, 5, 4, 5},
+		{4, 2, 5, 5, 5, 4, 5},
+		{4, 3, 5, 0, 4, 4, 5},
+		{2, 3, 5, 4, 5, 0, 4},
+	}
+
+	for i, test := range tests {
+		p := NewBinPartitioner(test.id, fakeRegistry(test.size), NewWilffBitset)
+		min, max, err := p.RangeLevel(test.level)
+		require.NoError(t, err)
+		require.Equal(t, test.peerMin, min, "test %d", i)
+		require.Equal(t, test.peerMax, max, "test %d", i)
+		min, max, err = p.RangeLevelInverse(test.level)
+		require.NoError(t, err)
+		require.Equal(t, test.ownMin, min, "test %d", i)
+		require.Equal(t, test.ownMax, max, "test %d", i)
+		ids, err := p.IdentitiesAt(test.level)
+		require.NoError(t, err)
+		require.Len(t, ids, test.peerMax-test.peerMin)
+	}
+
+	p := NewBinPartitioner(0, fakeRegistry(5), NewWilffBitset)
+	require.Equal(t, 3, p.MaxLevel())
+	_, _, err := p.RangeLevel(4)
+	require.Error(t, err)
+	_, _, err = p.RangeLevelInverse(-1)
+	require.Error(t, err)
+}
+
+func TestPartitionerBitSets(t *testing.T) {
+	size := 11
+	p := NewBinPartitioner(9, fakeRegistry(size), NewWilffBitset)
+	// the peers of node 9 at level 4 are [0,8)
+	local := NewWilffBitset(8)
+	local.Set(1, true)
+	local.Set(7, true)
+	global, err := p.GlobalBitSet(4, local)
+	require.NoError(t, err)
+	require.Equal(t, size, global.BitLength())
+	require.Equal(t, 2, global.Cardinality())
+	require.True(t, global.Get(1))
+	require.True(t, global.Get(7))
+
+	// the peers of node 9 at level 2 are [10,11)
+	local = NewWilffBitset(1)
+	local.Set(0, true)
+	global, err = p.GlobalBitSet(2, local)
+	require.NoError(t, err)
+	require.Equal(t, 1, global.Cardinality())
+	require.True(t, global.Get(10))
+
+	back, err := p.LevelBitSet(2, global)
+	require.NoError(t, err)
+	require.Equal(t, 1, back.BitLength())
+	require.True(t, back.Get(0))
+
+	// level 3 of node 9 has no peers
+	empty, err := p.LevelBitSet(3, global)
+	require.NoError(t, err)
+	require.Equal(t, 0, empty.BitLength())
+
+	_, err = p.GlobalBitSet(4, NewWilffBitset(3))
+	require.Error(t, err)
+	_, err = p.LevelBitSet(4, NewWilffBitset(3))
+	require.Error(t, err)
+}
@@ -0,0 +1,272 @@
+// Protobuf definition of the Handel packets, to carry Handel traffic inside
+// other protobuf messages. The conversions from and to the Handel types are
+// provided by the handel package, see Packet.ToProto and Packet.FromProto.
+//
+// Generate the Go code from the root of the repository with:
+//   protoc --go_out=. --go_opt=paths=source_relative pb/handel.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: pb/handel.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// MultiSignature is an aggregated signature alongside with the bitset of its
+// contributors.
+type MultiSignature struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// version of the encoding, it must be equal to handel.WireVersion
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// binary encoding of the bitset
+	Bitset []byte `protobuf:"bytes,2,opt,name=bitset,proto3" json:"bitset,omitempty"`
+	// binary encoding of the aggregated signature
+	Signature     []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MultiSignature) Reset() {
+	*x = MultiSignature{}
+	mi := &file_pb_handel_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiSignature) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiSignature) ProtoMessage() {}
+
+func (x *MultiSignature) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_handel_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiSignature.ProtoReflect.Descriptor instead.
+func (*MultiSignature) Descriptor() ([]byte, []int) {
+	return file_pb_handel_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MultiSignature) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *MultiSignature) GetBitset() []byte {
+	if x != nil {
+		return x.Bitset
+	}
+	return nil
+}
+
+func (x *MultiSignature) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// Packet is the message exchanged between Handel nodes.
+type Packet struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// version of the encoding, it must be equal to handel.WireVersion
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// identifier of the signature scheme of the multi-signature
+	Scheme uint32 `protobuf:"varint,2,opt,name=scheme,proto3" json:"scheme,omitempty"`
+	// identifier of the Handel session this packet belongs to
+	Session []byte `protobuf:"bytes,3,opt,name=session,proto3" json:"session,omitempty"`
+	// ID of the sender of this packet
+	Origin uint32 `protobuf:"varint,4,opt,name=origin,proto3" json:"origin,omitempty"`
+	// level of the Handel tree this packet is for
+	Level uint32 `protobuf:"varint,5,opt,name=level,proto3" json:"level,omitempty"`
+	// multi-signature of the sender at that level
+	MultiSig *MultiSignature `protobuf:"bytes,6,opt,name=multi_sig,json=multiSig,proto3" json:"multi_sig,omitempty"`
+	// optional individual signature of the sender
+	IndividualSig []byte `protobuf:"bytes,7,opt,name=individual_sig,json=individualSig,proto3" json:"individual_sig,omitempty"`
+	// optional data authenticating the rest of the packet
+	Envelope      []byte `protobuf:"bytes,8,opt,name=envelope,proto3" json:"envelope,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Packet) Reset() {
+	*x = Packet{}
+	mi := &file_pb_handel_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Packet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Packet) ProtoMessage() {}
+
+func (x *Packet) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_handel_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Packet.ProtoReflect.Descriptor instead.
+func (*Packet) Descriptor() ([]byte, []int) {
+	return file_pb_handel_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Packet) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Packet) GetScheme() uint32 {
+	if x != nil {
+		return x.Scheme
+	}
+	return 0
+}
+
+func (x *Packet) GetSession() []byte {
+	if x != nil {
+		return x.Session
+	}
+	return nil
+}
+
+func (x *Packet) GetOrigin() uint32 {
+	if x != nil {
+		return x.Origin
+	}
+	return 0
+}
+
+func (x *Packet) GetLevel() uint32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *Packet) GetMultiSig() *MultiSignature {
+	if x != nil {
+		return x.MultiSig
+	}
+	return nil
+}
+
+func (x *Packet) GetIndividualSig() []byte {
+	if x != nil {
+		return x.IndividualSig
+	}
+	return nil
+}
+
+func (x *Packet) GetEnvelope() []byte {
+	if x != nil {
+		return x.Envelope
+	}
+	return nil
+}
+
+var File_pb_handel_proto protoreflect.FileDescriptor
+
+const file_pb_handel_proto_rawDesc = "" +
+	"\n" +
+	"\x0fpb/handel.proto\x12\x06handel\"`\n" +
+	"\x0eMultiSignature\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x16\n" +
+	"\x06bitset\x18\x02 \x01(\fR\x06bitset\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature\"\xfa\x01\n" +
+	"\x06Packet\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x16\n" +
+	"\x06scheme\x18\x02 \x01(\rR\x06scheme\x12\x18\n" +
+	"\asession\x18\x03 \x01(\fR\asession\x12\x16\n" +
+	"\x06origin\x18\x04 \x01(\rR\x06origin\x12\x14\n" +
+	"\x05level\x18\x05 \x01(\rR\x05level\x123\n" +
+	"\tmulti_sig\x18\x06 \x01(\v2\x16.handel.MultiSignatureR\bmultiSig\x12%\n" +
+	"\x0eindividual_sig\x18\a \x01(\fR\rindividualSig\x12\x1a\n" +
+	"\benvelope\x18\b \x01(\fR\benvelopeB Z\x1egithub.com/ConsenSys/handel/pbb\x06proto3"
+
+var (
+	file_pb_handel_proto_rawDescOnce sync.Once
+	file_pb_handel_proto_rawDescData []byte
+)
+
+func file_pb_handel_proto_rawDescGZIP() []byte {
+	file_pb_handel_proto_rawDescOnce.Do(func() {
+		file_pb_handel_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pb_handel_proto_rawDesc), len(file_pb_handel_proto_rawDesc)))
+	})
+	return file_pb_handel_proto_rawDescData
+}
+
+var file_pb_handel_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_pb_handel_proto_goTypes = []any{
+	(*MultiSignature)(nil), // 0: handel.MultiSignature
+	(*Packet)(nil),         // 1: handel.Packet
+}
+var file_pb_handel_proto_depIdxs = []int32{
+	0, // 0: handel.Packet.multi_sig:type_name -> handel.MultiSignature
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_pb_handel_proto_init() }
+func file_pb_handel_proto_init() {
+	if File_pb_handel_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_handel_proto_rawDesc), len(file_pb_handel_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_pb_handel_proto_goTypes,
+		DependencyIndexes: file_pb_handel_proto_depIdxs,
+		MessageInfos:      file_pb_handel_proto_msgTypes,
+	}.Build()
+	File_pb_handel_proto = out.File
+	file_pb_handel_proto_goTypes = nil
+	file_pb_handel_proto_depIdxs = nil
+}
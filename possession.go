@@ -0,0 +1,59 @@
+package handel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PossessionProver is implemented by the secret keys able to prove the
+// possession of themselves. Proofs of possession defeat rogue-key attacks,
+// where a participant registers a public key crafted to cancel out the public
+// keys of others in order to forge multi-signatures: combining public keys is
+// only safe once each of them has proven the possession of its secret key.
+type PossessionProver interface {
+	// ProvePossession returns the proof of possession of the secret key, i.e.
+	// a signature of the public key under a domain different from the one of
+	// the messages.
+	ProvePossession() (Signature, error)
+}
+
+// PossessionVerifier is implemented by the public keys able to verify the
+// proofs of possession of their secret key.
+type PossessionVerifier interface {
+	// VerifyPossession returns an error if the proof is not a valid proof of
+	// possession of the secret key of the public key.
+	VerifyPossession(proof Signature) error
+}
+
+// VerifyPossession verifies the proof of possession of the secret key of the
+// identity. It returns an error if the proof is invalid or if the public key
+// of the identity can't verify proofs of possession.
+func VerifyPossession(id Identity, proof Signature) error {
+	v, ok := id.PublicKey().(PossessionVerifier)
+	if !ok {
+		return errors.New("handel: public key can't verify proofs of possession")
+	}
+	return v.VerifyPossession(proof)
+}
+
+// NewPossessionRegistry returns a Registry holding the identities of the given
+// registry, after verifying their proofs of possession: the proof of the
+// identity with ID i is proofs[i]. It returns an error if any proof is
+// invalid, such that no identity without a valid proof ever gets in a Handel
+// session. The identities are copied, so later changes of the given registry
+// are not taken into account.
+func NewPossessionRegistry(r Registry, proofs []Signature) (Registry, error) {
+	if len(proofs) != r.Size() {
+		return nil, fmt.Errorf("handel: %d proofs of possession for %d identities", len(proofs), r.Size())
+	}
+	ids, ok := r.Identities(0, r.Size())
+	if !ok {
+		return nil, errors.New("handel: registry can't give its identities")
+	}
+	for i, id := range ids {
+		if err := VerifyPossession(id, proofs[i]); err != nil {
+			return nil, fmt.Errorf("handel: identity %d refused: %v", id.ID(), err)
+		}
+	}
+	return NewArrayRegistry(append([]Identity{}, ids...)), nil
+}
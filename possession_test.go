@@ -0,0 +1,28 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPossessionRegistry(t *testing.T) {
+	reg := fakeRegistry(3)
+	proofs := []Signature{new(fakeSig), new(fakeSig), new(fakeSig)}
+	r, err := NewPossessionRegistry(reg, proofs)
+	require.NoError(t, err)
+	require.Equal(t, 3, r.Size())
+
+	proofs[1] = &fakeSig{bad: true}
+	_, err = NewPossessionRegistry(reg, proofs)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "identity 1")
+
+	_, err = NewPossessionRegistry(reg, proofs[:2])
+	require.Error(t, err)
+
+	// public keys without proofs of possession are refused
+	ids := []Identity{NewStaticIdentity(0, "", rosterKey{0x01})}
+	_, err = NewPossessionRegistry(NewArrayRegistry(ids), []Signature{new(fakeSig)})
+	require.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package handel
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ConsenSys/handel/pb"
+)
+
+// ToProto returns the protobuf representation of the multisignature, defined
+// in pb/handel.proto.
+func (m *MultiSignature) ToProto() (*pb.MultiSignature, error) {
+	bs, err := m.BitSet.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := m.Signature.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MultiSignature{
+		Version:   uint32(WireVersion),
+		Bitset:    bs,
+		Signature: sig,
+	}, nil
+}
+
+// FromProto reads a multisignature from its protobuf representation, using
+// the signature and bitset interface given. It returns ErrWireVersion if the
+// multisignature is encoded with another version than WireVersion.
+func (m *MultiSignature) FromProto(p *pb.MultiSignature, s Signature, bs BitSet) error {
+	if p.GetVersion() != uint32(WireVersion) {
+		return ErrWireVersion
+	}
+	return m.unmarshalParts(p.GetBitset(), p.GetSignature(), s, bs)
+}
+
+// ToProto returns the protobuf representation of the packet, defined in
+// pb/handel.proto. The multisignature is converted to its own protobuf
+// message, so it is not encoded twice.
+func (p *Packet) ToProto() (*pb.Packet, error) {
+	pp := &pb.Packet{
+		Version:       uint32(WireVersion),
+		Scheme:        uint32(p.Scheme),
+		Session:       p.Session,
+		Origin:        uint32(p.Origin),
+		Level:         uint32(p.Level),
+		IndividualSig: p.IndividualSig,
+		Envelope:      p.Envelope,
+	}
+	if len(p.MultiSig) > 0 {
+		bs, sig, err := decodeMultiSig(p.MultiSig)
+		if err != nil {
+			return nil, err
+		}
+		pp.MultiSig = &pb.MultiSignature{
+			Version:   uint32(WireVersion),
+			Bitset:    bs,
+			Signature: sig,
+		}
+	}
+	return pp, nil
+}
+
+// FromProto reads the packet from its protobuf representation. It returns
+// ErrWireVersion if the packet or its multisignature is encoded with another
+// version than WireVersion.
+func (p *Packet) FromProto(pp *pb.Packet) error {
+	if pp.GetVersion() != uint32(WireVersion) {
+		return ErrWireVersion
+	}
+	switch {
+	case pp.GetScheme() > math.MaxUint8:
+		return errors.New("handel: packet's scheme out of range")
+	case len(pp.GetSession()) > math.MaxUint8:
+		return errors.New("handel: packet's session too long")
+	case pp.GetOrigin() > math.MaxUint16:
+		return errors.New("handel: packet's origin out of range")
+	case pp.GetLevel() > math.MaxUint8:
+		return errors.New("handel: packet's level out of range")
+	}
+	var multiSig []byte
+	if ms := pp.GetMultiSig(); ms != nil {
+		if ms.GetVersion() != uint32(WireVersion) {
+			return ErrWireVersion
+		}
+		var err error
+		multiSig, err = encodeMultiSig(ms.GetBitset(), ms.GetSignature())
+		if err != nil {
+			return err
+		}
+	}
+	*p = Packet{
+		Scheme:        byte(pp.GetScheme()),
+		Session:       pp.GetSession(),
+		Origin:        uint16(pp.GetOrigin()),
+		Level:         byte(pp.GetLevel()),
+		MultiSig:      multiSig,
+		IndividualSig: pp.GetIndividualSig(),
+		Envelope:      pp.GetEnvelope(),
+	}
+	return nil
+}
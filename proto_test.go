@@ -0,0 +1,69 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/ConsenSys/handel/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMultiSignatureProto(t *testing.T) {
+	ms := newTestMultiSig(10, 1, 9)
+	pms, err := ms.ToProto()
+	require.NoError(t, err)
+
+	ms2 := new(MultiSignature)
+	require.NoError(t, ms2.FromProto(pms, new(fakeSig), new(WilffBitSet)))
+	require.Equal(t, ms.BitSet, ms2.BitSet)
+
+	pms.Version++
+	require.Equal(t, ErrWireVersion, ms2.FromProto(pms, new(fakeSig), new(WilffBitSet)))
+}
+
+func TestPacketProto(t *testing.T) {
+	buff, err := newTestMultiSig(4, 2).MarshalBinary()
+	require.NoError(t, err)
+	p1 := &Packet{
+		Scheme:        1,
+		Session:       []byte("session"),
+		Origin:        3,
+		Level:         2,
+		MultiSig:      buff,
+		IndividualSig: []byte("me"),
+		Envelope:      []byte("sealed"),
+	}
+
+	pp, err := p1.ToProto()
+	require.NoError(t, err)
+	// the packet can be carried inside other protobuf messages
+	encoded, err := proto.Marshal(pp)
+	require.NoError(t, err)
+	pp2 := new(pb.Packet)
+	require.NoError(t, proto.Unmarshal(encoded, pp2))
+
+	p2 := new(Packet)
+	require.NoError(t, p2.FromProto(pp2))
+	require.Equal(t, p1, p2)
+
+	// the packet without a multi-signature
+	pp, err = (&Packet{Origin: 1, Level: 1}).ToProto()
+	require.NoError(t, err)
+	require.Nil(t, pp.MultiSig)
+	require.NoError(t, p2.FromProto(pp))
+	require.Nil(t, p2.MultiSig)
+
+	_, err = (&Packet{MultiSig: []byte{0x01}}).ToProto()
+	require.Error(t, err)
+
+	var invalid = []*pb.Packet{
+		{Version: uint32(WireVersion) + 1},
+		{Version: uint32(WireVersion), MultiSig: &pb.MultiSignature{}},
+		{Version: uint32(WireVersion), Scheme: 256},
+		{Version: uint32(WireVersion), Origin: 1 << 16},
+		{Version: uint32(WireVersion), Level: 256},
+	}
+	for i, pp := range invalid {
+		require.Error(t, new(Packet).FromProto(pp), "test %d", i)
+	}
+}
@@ -0,0 +1,164 @@
+package handel
+
+// Evaluator scores the incoming multi-signatures so Handel verifies first the
+// ones that improve the most its aggregated multi-signature.
+type Evaluator interface {
+	// Evaluate returns the score of the incoming multi-signature received at
+	// the given level, given the best multi-signature verified so far at that
+	// level, which is nil if there is none. Multi-signatures with higher
+	// scores are verified first, and the ones whose score is zero or negative
+	// are discarded without verification.
+	Evaluate(level int, incoming, best *MultiSignature) int
+}
+
+// gainEvaluator scores multi-signatures by the number of contributions they
+// add to the level, prioritizing the lower levels since completing them allows
+// to start the next ones.
+type gainEvaluator struct{}
+
+// maxLevels is the highest number of levels a Handel tree can have, since the
+// origin of a Packet is an uint16.
+const maxLevels = 16
+
+func (g *gainEvaluator) Evaluate(level int, incoming, best *MultiSignature) int {
+	gain := incoming.Cardinality()
+	if best != nil {
+		gain -= best.Cardinality()
+	}
+	if gain <= 0 {
+		return 0
+	}
+	// a gain is always lower than 2^16 so any multi-signature of a lower level
+	// gets a higher score than the ones of the upper levels
+	return (maxLevels+1-level)<<16 + gain
+}
+
+// QueuePolicy indicates which multi-signature is dropped when a new one
+// arrives while the verification queue is full.
+type QueuePolicy int
+
+const (
+	// DropLowest drops the multi-signature with the lowest score, which can
+	// be the new one.
+	DropLowest QueuePolicy = iota
+	// DropNewest drops the new multi-signature.
+	DropNewest
+)
+
+// pendingSig is a parsed multi-signature waiting to be verified.
+type pendingSig struct {
+	origin int
+	level  int
+	ms     *MultiSignature
+	// individual signature of the origin, if any
+	ind   Signature
+	score int
+	// true if the packet's origin has been authenticated by the Network
+	authenticated bool
+}
+
+// verifQueue holds the multi-signatures waiting to be verified, and gives
+// them out by order of their score. It holds at most size multi-signatures.
+type verifQueue struct {
+	eval    Evaluator
+	size    int
+	policy  QueuePolicy
+	pending []*pendingSig
+}
+
+func newVerifQueue(e Evaluator, size int, policy QueuePolicy) *verifQueue {
+	return &verifQueue{
+		eval:   e,
+		size:   size,
+		policy: policy,
+	}
+}
+
+// push scores the multi-signature against the current best one of its level
+// and adds it to the queue if it is worth verifying. A pending
+// multi-signature from the same origin and level is replaced if the new one
+// has more contributions, since it supersedes it. If the queue is full, the
+// queue's policy decides which multi-signature is dropped. It returns true if
+// the multi-signature has been added.
+func (q *verifQueue) push(sp *pendingSig, levels []*level) bool {
+	sp.score = q.eval.Evaluate(sp.level, sp.ms, levels[sp.level].best)
+	if sp.score <= 0 {
+		return false
+	}
+	for i, p := range q.pending {
+		if p.origin != sp.origin || p.level != sp.level {
+			continue
+		}
+		if p.ms.Cardinality() < sp.ms.Cardinality() {
+			q.pending[i] = sp
+			return true
+		}
+		return false
+	}
+	if len(q.pending) < q.size {
+		q.pending = append(q.pending, sp)
+		return true
+	}
+	if q.policy == DropNewest {
+		return false
+	}
+	lowest := 0
+	for i, p := range q.pending {
+		if p.score < q.pending[lowest].score {
+			lowest = i
+		}
+	}
+	if q.pending[lowest].score >= sp.score {
+		return false
+	}
+	q.pending[lowest] = sp
+	return true
+}
+
+// pop scores again all pending multi-signatures against the current best ones
+// of their level, discards the ones whose score is not positive, and returns
+// the one with the highest score. It returns nil if no multi-signatures are
+// worth verifying.
+func (q *verifQueue) pop(levels []*level) *pendingSig {
+	var best *pendingSig
+	var bestIdx int
+	kept := q.pending[:0]
+	for _, p := range q.pending {
+		p.score = q.eval.Evaluate(p.level, p.ms, levels[p.level].best)
+		if p.score <= 0 {
+			continue
+		}
+		if best == nil || p.score > best.score {
+			best = p
+			bestIdx = len(kept)
+		}
+		kept = append(kept, p)
+	}
+	if best != nil {
+		kept = append(kept[:bestIdx], kept[bestIdx+1:]...)
+	}
+	for i := len(kept); i < len(q.pending); i++ {
+		q.pending[i] = nil
+	}
+	q.pending = kept
+	return best
+}
+
+// remove discards all the pending multi-signatures from the given origin.
+func (q *verifQueue) remove(origin int) {
+	kept := q.pending[:0]
+	for _, p := range q.pending {
+		if p.origin != origin {
+			kept = append(kept, p)
+		}
+	}
+	for i := len(kept); i < len(q.pending); i++ {
+		q.pending[i] = nil
+	}
+	q.pending = kept
+}
+
+// len returns the number of multi-signatures waiting in the queue.
+func (q *verifQueue) len() int {
+	return len(q.pending)
+}
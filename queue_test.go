@@ -0,0 +1,91 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMultiSig(bitlength int, bits ...int) *MultiSignature {
+	bs := NewWilffBitset(bitlength)
+	for _, b := range bits {
+		bs.Set(b, true)
+	}
+	return &MultiSignature{BitSet: bs, Signature: new(fakeSig)}
+}
+
+func TestGainEvaluator(t *testing.T) {
+	e := new(gainEvaluator)
+	best := newTestMultiSig(4, 0, 1)
+	// dominated multi-signatures
+	require.Equal(t, 0, e.Evaluate(2, newTestMultiSig(4, 2, 3), best))
+	require.Equal(t, 0, e.Evaluate(2, newTestMultiSig(4, 3), best))
+	// bigger gain gives bigger score
+	s1 := e.Evaluate(2, newTestMultiSig(4, 1, 2, 3), best)
+	s2 := e.Evaluate(2, newTestMultiSig(4, 0, 1, 2, 3), best)
+	require.True(t, s1 > 0)
+	require.True(t, s2 > s1)
+	// lower levels first
+	s3 := e.Evaluate(1, newTestMultiSig(1, 0), nil)
+	require.True(t, s3 > s2)
+}
+
+func TestVerifQueue(t *testing.T) {
+	levels := []*level{newLevel(0, 0, 1, nil), newLevel(1, 1, 2, nil), newLevel(2, 2, 4, nil), newLevel(3, 4, 8, nil)}
+	q := newVerifQueue(new(gainEvaluator), 10, DropLowest)
+	require.Nil(t, q.pop(levels))
+
+	require.True(t, q.push(&pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 0)}, levels))
+	require.True(t, q.push(&pendingSig{origin: 5, level: 3, ms: newTestMultiSig(4, 1, 2)}, levels))
+	require.True(t, q.push(&pendingSig{origin: 2, level: 2, ms: newTestMultiSig(2, 0)}, levels))
+	// superseded by the previous one from the same origin
+	require.False(t, q.push(&pendingSig{origin: 5, level: 3, ms: newTestMultiSig(4, 1)}, levels))
+	// supersedes the previous one from the same origin
+	require.True(t, q.push(&pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 0, 3)}, levels))
+	require.Equal(t, 3, q.len())
+
+	sp := q.pop(levels)
+	require.Equal(t, 2, sp.origin)
+	levels[2].best = sp.ms
+	// dominated by the best one of the level
+	require.False(t, q.push(&pendingSig{origin: 3, level: 2, ms: newTestMultiSig(2, 1)}, levels))
+
+	sp = q.pop(levels)
+	require.Equal(t, 4, sp.origin)
+	require.Equal(t, 2, sp.ms.Cardinality())
+	levels[3].best = newTestMultiSig(4, 0, 1, 2)
+
+	// the last one is now dominated
+	require.Nil(t, q.pop(levels))
+	require.Equal(t, 0, q.len())
+}
+
+func TestVerifQueuePolicies(t *testing.T) {
+	levels := []*level{newLevel(0, 0, 1, nil), newLevel(1, 1, 2, nil), newLevel(2, 2, 4, nil), newLevel(3, 4, 8, nil)}
+	low := &pendingSig{origin: 4, level: 3, ms: newTestMultiSig(4, 0)}
+	high := &pendingSig{origin: 5, level: 3, ms: newTestMultiSig(4, 1, 2)}
+	higher := &pendingSig{origin: 2, level: 2, ms: newTestMultiSig(2, 0)}
+
+	q := newVerifQueue(new(gainEvaluator), 2, DropNewest)
+	require.True(t, q.push(low, levels))
+	require.True(t, q.push(high, levels))
+	require.False(t, q.push(higher, levels))
+	require.Equal(t, high, q.pop(levels))
+	require.Equal(t, low, q.pop(levels))
+
+	q = newVerifQueue(new(gainEvaluator), 2, DropLowest)
+	require.True(t, q.push(high, levels))
+	require.True(t, q.push(higher, levels))
+	require.False(t, q.push(low, levels))
+	require.Equal(t, 2, q.len())
+	require.Equal(t, higher, q.pop(levels))
+	require.Equal(t, high, q.pop(levels))
+
+	q = newVerifQueue(new(gainEvaluator), 2, DropLowest)
+	require.True(t, q.push(low, levels))
+	require.True(t, q.push(high, levels))
+	require.True(t, q.push(higher, levels))
+	require.Equal(t, higher, q.pop(levels))
+	require.Equal(t, high, q.pop(levels))
+	require.Nil(t, q.pop(levels))
+}
@@ -0,0 +1,241 @@
+package handel
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// PublicKeyDecoder decodes the binary encoding of a public key of a signature
+// scheme.
+type PublicKeyDecoder func(buff []byte) (PublicKey, error)
+
+var decodersMu sync.RWMutex
+var decoders = make(map[byte]PublicKeyDecoder)
+
+// RegisterPublicKeyDecoder registers the decoder of the public keys of the
+// signature scheme with the given identifier, as returned by
+// SignatureScheme.ID. The packages implementing signature schemes register
+// their decoder when they are imported, so their public keys can be read from
+// roster files.
+func RegisterPublicKeyDecoder(scheme byte, d PublicKeyDecoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[scheme] = d
+}
+
+func publicKeyDecoder(scheme byte) (PublicKeyDecoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	d, ok := decoders[scheme]
+	return d, ok
+}
+
+// RosterFormat is the encoding of a roster file, i.e. a file listing the ID,
+// address and hexadecimal public key of each Handel node.
+type RosterFormat int
+
+const (
+	// JSONRoster encodes the roster as a JSON object whose "identities" field
+	// is the array of identities, each having an "id", an "address" and a
+	// "public_key".
+	JSONRoster RosterFormat = iota
+	// TOMLRoster encodes the roster as a TOML array of tables named
+	// "identities", each having an "id", an "address" and a "public_key".
+	TOMLRoster
+	// CSVRoster encodes the roster as CSV records with the ID, address and
+	// public key of each identity, after a header record.
+	CSVRoster
+)
+
+// RosterFormatOf returns the format of a roster file deduced from its
+// extension: ".json", ".toml" or ".csv".
+func RosterFormatOf(path string) (RosterFormat, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSONRoster, nil
+	case ".toml":
+		return TOMLRoster, nil
+	case ".csv":
+		return CSVRoster, nil
+	}
+	return 0, fmt.Errorf("handel: unknown roster format of %s", path)
+}
+
+// rosterHeader is the header record of the CSV rosters.
+var rosterHeader = []string{"id", "address", "public_key"}
+
+// rosterEntry is the encoding of an identity in a roster file.
+type rosterEntry struct {
+	ID        int    `json:"id" toml:"id"`
+	Address   string `json:"address" toml:"address"`
+	PublicKey string `json:"public_key" toml:"public_key"`
+}
+
+// roster is the encoding of a roster file in the JSON and TOML formats.
+type roster struct {
+	Identities []rosterEntry `json:"identities" toml:"identities"`
+}
+
+// LoadRoster reads the roster file at the given path, in the format given by
+// its extension. See ReadRoster.
+func LoadRoster(path string, scheme byte) (Registry, error) {
+	format, err := RosterFormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRoster(f, format, scheme)
+}
+
+// ReadRoster reads a roster in the given format and returns the Registry of
+// its identities. The public keys are decoded with the PublicKeyDecoder
+// registered for the given signature scheme. The IDs must go from 0 to the
+// number of identities minus one, in any order, and each public key must be
+// unique.
+func ReadRoster(r io.Reader, format RosterFormat, scheme byte) (Registry, error) {
+	decode, ok := publicKeyDecoder(scheme)
+	if !ok {
+		return nil, fmt.Errorf("handel: no public key decoder registered for scheme %d", scheme)
+	}
+	entries, err := readRosterEntries(r, format)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	ids := make([]Identity, len(entries))
+	keys := make(map[string]int, len(entries))
+	for i, e := range entries {
+		switch {
+		case i > 0 && e.ID == entries[i-1].ID:
+			return nil, fmt.Errorf("handel: roster has duplicate ID %d", e.ID)
+		case e.ID != i:
+			return nil, fmt.Errorf("handel: roster misses ID %d", i)
+		}
+		buff, err := hex.DecodeString(strings.TrimPrefix(e.PublicKey, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("handel: roster has invalid public key for ID %d: %v", e.ID, err)
+		}
+		if other, ok := keys[string(buff)]; ok {
+			return nil, fmt.Errorf("handel: roster has duplicate public key for IDs %d and %d", other, e.ID)
+		}
+		keys[string(buff)] = e.ID
+		pub, err := decode(buff)
+		if err != nil {
+			return nil, fmt.Errorf("handel: roster has invalid public key for ID %d: %v", e.ID, err)
+		}
+		ids[i] = NewStaticIdentity(e.ID, e.Address, pub)
+	}
+	return NewArrayRegistry(ids), nil
+}
+
+func readRosterEntries(r io.Reader, format RosterFormat) ([]rosterEntry, error) {
+	var ros roster
+	switch format {
+	case JSONRoster:
+		if err := json.NewDecoder(r).Decode(&ros); err != nil {
+			return nil, err
+		}
+		return ros.Identities, nil
+	case TOMLRoster:
+		if _, err := toml.NewDecoder(r).Decode(&ros); err != nil {
+			return nil, err
+		}
+		return ros.Identities, nil
+	case CSVRoster:
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 && strings.EqualFold(records[0][0], rosterHeader[0]) {
+			records = records[1:]
+		}
+		entries := make([]rosterEntry, len(records))
+		for i, rec := range records {
+			if len(rec) != len(rosterHeader) {
+				return nil, fmt.Errorf("handel: roster record %d has %d fields", i, len(rec))
+			}
+			id, err := strconv.Atoi(rec[0])
+			if err != nil {
+				return nil, fmt.Errorf("handel: roster record %d has invalid ID: %v", i, err)
+			}
+			entries[i] = rosterEntry{ID: id, Address: rec[1], PublicKey: rec[2]}
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("handel: unknown roster format %d", format)
+}
+
+// SaveRoster writes the roster of the given identities to the file at the
+// given path, in the format given by its extension. See WriteRoster.
+func SaveRoster(path string, ids []Identity) error {
+	format, err := RosterFormatOf(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteRoster(f, format, ids); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteRoster writes the roster of the given identities in the given format,
+// so it can be read back with ReadRoster. The public keys of the identities
+// must implement encoding.BinaryMarshaler.
+func WriteRoster(w io.Writer, format RosterFormat, ids []Identity) error {
+	entries := make([]rosterEntry, len(ids))
+	for i, id := range ids {
+		m, ok := id.PublicKey().(encoding.BinaryMarshaler)
+		if !ok {
+			return fmt.Errorf("handel: public key of ID %d can't be marshalled", id.ID())
+		}
+		buff, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		entries[i] = rosterEntry{
+			ID:        id.ID(),
+			Address:   id.Address(),
+			PublicKey: hex.EncodeToString(buff),
+		}
+	}
+	switch format {
+	case JSONRoster:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(&roster{entries})
+	case TOMLRoster:
+		return toml.NewEncoder(w).Encode(&roster{entries})
+	case CSVRoster:
+		cw := csv.NewWriter(w)
+		cw.Write(rosterHeader)
+		for _, e := range entries {
+			cw.Write([]string{strconv.Itoa(e.ID), e.Address, e.PublicKey})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("handel: unknown roster format %d", format)
+}
@@ -0,0 +1,84 @@
+package handel
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rosterKey is a public key marshalled as its own bytes.
+type rosterKey []byte
+
+func (r rosterKey) String() string                                { return string(r) }
+func (r rosterKey) VerifySignature(msg []byte, s Signature) error { return nil }
+func (r rosterKey) Combine(PublicKey) PublicKey                   { return r }
+func (r rosterKey) Negate() PublicKey                             { return r }
+func (r rosterKey) MarshalBinary() ([]byte, error)                { return r, nil }
+
+const rosterScheme = 0xfd
+
+func init() {
+	RegisterPublicKeyDecoder(rosterScheme, func(buff []byte) (PublicKey, error) {
+		return rosterKey(buff), nil
+	})
+}
+
+func rosterIdentities(n int) []Identity {
+	ids := make([]Identity, n)
+	for i := range ids {
+		ids[i] = NewStaticIdentity(i, "127.0.0.1:"+strconv.Itoa(3000+i), rosterKey{byte(i), 0xff})
+	}
+	return ids
+}
+
+func TestRosterRoundTrip(t *testing.T) {
+	ids := rosterIdentities(5)
+	dir := t.TempDir()
+	for _, name := range []string{"roster.json", "roster.toml", "roster.csv"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, SaveRoster(path, ids), name)
+		reg, err := LoadRoster(path, rosterScheme)
+		require.NoError(t, err, name)
+		require.Equal(t, len(ids), reg.Size(), name)
+		for i, id := range ids {
+			id2, ok := reg.Identity(i)
+			require.True(t, ok)
+			require.Equal(t, id.ID(), id2.ID(), name)
+			require.Equal(t, id.Address(), id2.Address(), name)
+			require.Equal(t, id.PublicKey(), id2.PublicKey(), name)
+		}
+	}
+	_, err := RosterFormatOf("roster.yaml")
+	require.Error(t, err)
+	_, err = LoadRoster(filepath.Join(dir, "roster.json"), 0x42)
+	require.Error(t, err)
+	require.Error(t, WriteRoster(new(bytes.Buffer), JSONRoster, fakeIdentities(2)))
+}
+
+func TestReadRoster(t *testing.T) {
+	var tests = []struct {
+		roster string
+		valid  bool
+	}{
+		// unordered IDs and no header
+		{"1,addr1,0x0102\n0,addr0,0a0b\n", true},
+		{"id,address,public_key\n0,addr0,0a0b\n", true},
+		{"0,addr0,0a0b\n0,addr1,0102\n", false},
+		{"0,addr0,0a0b\n2,addr2,0102\n", false},
+		{"0,addr0,0a0b\n1,addr1,0A0B\n", false},
+		{"0,addr0,zz\n", false},
+		{"a,addr0,0a0b\n", false},
+	}
+	for i, test := range tests {
+		_, err := ReadRoster(strings.NewReader(test.roster), CSVRoster, rosterScheme)
+		if test.valid {
+			require.NoError(t, err, "test %d", i)
+		} else {
+			require.Error(t, err, "test %d", i)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handel
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+)
+
+// CandidateSelector selects the peers to which a Handel node sends its
+// multi-signatures at each level.
+type CandidateSelector interface {
+	// Select returns the next peers to contact at the given level, at most
+	// count of them.
+	Select(level, count int) []Identity
+}
+
+// randomSelector is a CandidateSelector that walks through a pseudo-random
+// permutation of the peers of each level. The permutations are derived from a
+// seed so the same seed always gives the same ordering of peers.
+type randomSelector struct {
+	part Partitioner
+	seed []byte
+	// permutation of the peers of each level, lazily computed
+	perms map[int][]Identity
+	// index in the permutation of the next peer to select, per level
+	next map[int]int
+}
+
+// NewRandomSelector returns a CandidateSelector that orders the peers of each
+// level with a pseudo-random permutation derived from the given seed. It
+// selects the peers in that order and only selects a peer again once all the
+// others have been selected.
+func NewRandomSelector(p Partitioner, seed []byte) CandidateSelector {
+	return &randomSelector{
+		part:  p,
+		seed:  seed,
+		perms: make(map[int][]Identity),
+		next:  make(map[int]int),
+	}
+}
+
+func (r *randomSelector) Select(level, count int) []Identity {
+	perm := r.permutation(level)
+	if count > len(perm) {
+		count = len(perm)
+	}
+	ids := make([]Identity, 0, count)
+	next := r.next[level]
+	for i := 0; i < count; i++ {
+		ids = append(ids, perm[next])
+		next = (next + 1) % len(perm)
+	}
+	r.next[level] = next
+	return ids
+}
+
+// permutation returns the ordering of the peers of the given level.
+func (r *randomSelector) permutation(level int) []Identity {
+	if perm, ok := r.perms[level]; ok {
+		return perm
+	}
+	nodes, err := r.part.IdentitiesAt(level)
+	if err != nil {
+		nodes = nil
+	}
+	h := sha256.New()
+	h.Write(r.seed)
+	binary.Write(h, binary.BigEndian, uint32(level))
+	src := rand.NewSource(int64(binary.BigEndian.Uint64(h.Sum(nil))))
+	perm := make([]Identity, len(nodes))
+	for i, j := range rand.New(src).Perm(len(nodes)) {
+		perm[i] = nodes[j]
+	}
+	r.perms[level] = perm
+	return perm
+}
+
+// selectorSeed returns the seed of the CandidateSelector of a Handel node: the
+// hash of the message being signed and of the node's ID.
+func selectorSeed(msg []byte, id int) []byte {
+	h := sha256.New()
+	h.Write(msg)
+	binary.Write(h, binary.BigEndian, uint32(id))
+	return h.Sum(nil)
+}
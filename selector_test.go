@@ -0,0 +1,52 @@
+package handel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandomSelector(t *testing.T) {
+	n := 37
+	reg := fakeRegistry(n)
+	part := NewBinPartitioner(3, reg, NewWilffBitset)
+	seed := selectorSeed(msg, 3)
+	level := part.MaxLevel()
+	peers, err := part.IdentitiesAt(level)
+	require.NoError(t, err)
+
+	sel := NewRandomSelector(part, seed)
+	count := 4
+	seen := make(map[int]bool)
+	var order []Identity
+	for len(seen) < len(peers) {
+		ids := sel.Select(level, count)
+		require.True(t, len(ids) <= count)
+		for _, id := range ids {
+			if len(seen) == len(peers) {
+				break
+			}
+			require.False(t, seen[id.ID()], "peer %d selected twice", id.ID())
+			seen[id.ID()] = true
+			order = append(order, id)
+		}
+	}
+
+	// same seed gives the same ordering
+	sel2 := NewRandomSelector(part, seed)
+	var order2 []Identity
+	for len(order2) < len(order) {
+		order2 = append(order2, sel2.Select(level, 1)...)
+	}
+	require.Equal(t, order, order2)
+
+	// a different seed gives another ordering
+	sel3 := NewRandomSelector(part, selectorSeed(msg, 4))
+	require.NotEqual(t, order, sel3.Select(level, len(peers)))
+
+	// more candidates than peers
+	require.Len(t, sel.Select(1, 10), 1)
+	// level without peers
+	part = NewBinPartitioner(36, reg, NewWilffBitset)
+	require.Len(t, NewRandomSelector(part, seed).Select(1, 10), 0)
+}
@@ -0,0 +1,432 @@
+package handel
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionConfig holds the parameters of a SessionManager.
+type SessionConfig struct {
+	// Retention is the time a finished session is kept, so the late packets of
+	// the session are recognized and dropped. It is also the time a session
+	// created on demand for early packets waits for its Handel instance
+	// before being discarded, and the time a claimed session can go without
+	// any packet sent or received before being finished, for example when its
+	// Handel instance is never started.
+	Retention time.Duration
+	// MaxPendingPackets is the maximum number of packets buffered for a
+	// session that has no listeners yet. The packets arriving while the buffer
+	// is full are dropped.
+	MaxPendingPackets int
+	// MaxPendingSessions is the maximum number of sessions created on demand
+	// for early packets at any time. Beyond that number, the oldest of these
+	// sessions is discarded to make room for a new one.
+	MaxPendingSessions int
+	// Schemes holds the IDs of the signature schemes, as returned by
+	// SignatureScheme.ID, of the sessions run by the manager. The schemes of
+	// the Handel instances created by NewHandel are added to them. Packets of
+	// unknown sessions using another scheme are dropped without creating a
+	// session. If no scheme is known yet, packets of any scheme are accepted.
+	Schemes []byte
+}
+
+// DefaultSessionRetention is the default time finished sessions are kept.
+const DefaultSessionRetention = time.Minute
+
+// DefaultMaxPendingPackets is the default number of packets buffered for a
+// session that has no listeners yet.
+const DefaultMaxPendingPackets = 1000
+
+// DefaultMaxPendingSessions is the default number of sessions created on
+// demand for early packets at any time.
+const DefaultMaxPendingSessions = 16
+
+// DefaultSessionConfig returns the default parameters of a SessionManager.
+func DefaultSessionConfig() *SessionConfig {
+	return &SessionConfig{
+		Retention:          DefaultSessionRetention,
+		MaxPendingPackets:  DefaultMaxPendingPackets,
+		MaxPendingSessions: DefaultMaxPendingSessions,
+	}
+}
+
+func mergeSessionConfig(c *SessionConfig) *SessionConfig {
+	n := *c
+	if n.Retention <= 0 {
+		n.Retention = DefaultSessionRetention
+	}
+	if n.MaxPendingPackets <= 0 {
+		n.MaxPendingPackets = DefaultMaxPendingPackets
+	}
+	if n.MaxPendingSessions <= 0 {
+		n.MaxPendingSessions = DefaultMaxPendingSessions
+	}
+	return &n
+}
+
+// ErrSessionExists is returned when creating a session that is already
+// running or finished in a SessionManager.
+var ErrSessionExists = errors.New("handel: session already exists")
+
+// ErrSessionFinished is returned when sending a packet over the Network of a
+// finished session.
+var ErrSessionFinished = errors.New("handel: session finished")
+
+// SessionManager multiplexes concurrent Handel sessions over one Network. The
+// packets are routed to the right session by their Session identifier. Each
+// session is given its own Network, which stamps the session identifier on the
+// packets sent out and only receives the packets of the session. Packets
+// arriving for an unknown session are buffered in a session created on
+// demand, until a Handel instance for that session is created. Sessions are
+// removed once they are finished, after the configured retention time.
+// SessionManager is thread-safe.
+type SessionManager struct {
+	sync.Mutex
+	net      Network
+	c        *SessionConfig
+	sessions map[string]*session
+	// IDs of the signature schemes accepted for unknown sessions
+	schemes map[byte]bool
+	stopped bool
+	done    chan struct{}
+}
+
+// session is the routing state of one session.
+type session struct {
+	id        []byte
+	listeners []Listener
+	// true once a Network has been returned for that session, i.e. the
+	// session is not only created on demand for early packets
+	claimed bool
+	// packets received while the session has no listeners
+	pending []*Packet
+	// time at which the session was created, or finished if it is finished
+	since    time.Time
+	finished bool
+	// closed once the session is finished
+	done chan struct{}
+	// time in nanoseconds of the last packet sent or received once the
+	// session is claimed, accessed atomically
+	active int64
+}
+
+func newSession(id []byte, now time.Time) *session {
+	return &session{id: id, since: now, done: make(chan struct{})}
+}
+
+// touch records activity on the session at the given time.
+func (s *session) touch(now time.Time) {
+	atomic.StoreInt64(&s.active, now.UnixNano())
+}
+
+// idle returns the time elapsed since the last activity on the session.
+func (s *session) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&s.active)))
+}
+
+// NewSessionManager returns a SessionManager dispatching the packets received
+// from the given Network. The first config in the slice is taken if not nil,
+// missing fields being set to their default value. Otherwise, the default
+// config generated by DefaultSessionConfig() is used. The SessionManager
+// registers itself as a Listener to the network, and garbage-collects the
+// sessions in the background until Stop is called.
+func NewSessionManager(n Network, conf ...*SessionConfig) *SessionManager {
+	c := DefaultSessionConfig()
+	if len(conf) > 0 && conf[0] != nil {
+		c = mergeSessionConfig(conf[0])
+	}
+	m := &SessionManager{
+		net:      n,
+		c:        c,
+		sessions: make(map[string]*session),
+		schemes:  make(map[byte]bool),
+		done:     make(chan struct{}),
+	}
+	for _, id := range c.Schemes {
+		m.schemes[id] = true
+	}
+	n.RegisterListener(m)
+	go m.run()
+	return m
+}
+
+// NewHandel creates a Handel instance running the session given by the
+// config's Session, which defaults to the hash of the message, over the
+// Network of the session. The parameters are the same as for the NewHandel
+// function. The packets buffered for that session are dispatched to the
+// Handel instance. The session is finished when the instance is stopped, and
+// the instance is stopped when the session is finished, by Finish or after
+// being idle for the retention time.
+func (m *SessionManager) NewHandel(r Registry, id Identity, s SignatureScheme, msg []byte,
+	conf ...*Config) (*Handel, error) {
+	var c *Config
+	if len(conf) > 0 {
+		c = conf[0]
+	}
+	var sid []byte
+	if c != nil {
+		sid = c.Session
+	}
+	sid = sessionID(sid, msg)
+	m.Lock()
+	m.schemes[s.ID()] = true
+	m.Unlock()
+	n, err := m.Network(sid)
+	if err != nil {
+		return nil, err
+	}
+	h, err := NewHandel(n, r, id, s, msg, c)
+	if err != nil {
+		m.release(sid)
+		return nil, err
+	}
+	sess := n.(*sessionNetwork).s
+	go func() {
+		select {
+		case <-h.done:
+			m.Lock()
+			m.finish(sess, time.Now())
+			m.Unlock()
+		case <-sess.done:
+			h.Stop()
+		case <-m.done:
+		}
+	}()
+	return h, nil
+}
+
+// Network returns the Network of the given session. It returns
+// ErrSessionExists if a Network has already been returned for that session,
+// or if the session is finished.
+// The packets sent out over the returned Network are stamped with the session
+// identifier, and its Listeners only receive the packets of the session. The
+// first Listener registered gets the packets buffered so far for the session.
+func (m *SessionManager) Network(sid []byte) (Network, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.stopped {
+		return nil, errors.New("handel: session manager stopped")
+	}
+	s, ok := m.sessions[string(sid)]
+	if ok && (s.claimed || s.finished) {
+		return nil, ErrSessionExists
+	}
+	now := time.Now()
+	if !ok {
+		s = newSession(sid, now)
+		m.sessions[string(sid)] = s
+	}
+	s.claimed = true
+	s.touch(now)
+	return &sessionNetwork{m, s}, nil
+}
+
+// Finish marks the given session as finished: its packets are dropped from
+// now on, and the session is removed after the retention time. Sessions of
+// the Handel instances created by NewHandel are finished when the instances
+// are stopped, and finishing them stops the instances.
+func (m *SessionManager) Finish(sid []byte) {
+	m.Lock()
+	defer m.Unlock()
+	if s, ok := m.sessions[string(sid)]; ok {
+		m.finish(s, time.Now())
+	}
+}
+
+// finish marks the session as finished at the given time. This method is NOT
+// thread-safe and only meant for internal use.
+func (m *SessionManager) finish(s *session, now time.Time) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.since = now
+	s.listeners = nil
+	s.pending = nil
+	close(s.done)
+}
+
+// release gives back a session whose Network is not used, so its packets are
+// buffered again until a Network is claimed for it.
+func (m *SessionManager) release(sid []byte) {
+	m.Lock()
+	defer m.Unlock()
+	if s, ok := m.sessions[string(sid)]; ok && !s.finished {
+		s.claimed = false
+		s.listeners = nil
+	}
+}
+
+// NewPacket implements the Listener interface. It dispatches the packet to the
+// listeners of its session, or buffers it if the session has no listeners yet.
+func (m *SessionManager) NewPacket(p *Packet) error {
+	m.Lock()
+	if m.stopped {
+		m.Unlock()
+		return nil
+	}
+	now := time.Now()
+	s, ok := m.sessions[string(p.Session)]
+	if !ok {
+		if len(m.schemes) > 0 && !m.schemes[p.Scheme] {
+			m.Unlock()
+			return errors.New("handel: packet's signature scheme unknown")
+		}
+		if m.pendingSessions() >= m.c.MaxPendingSessions {
+			m.evictPending()
+		}
+		s = newSession(p.Session, now)
+		m.sessions[string(p.Session)] = s
+	}
+	if s.finished {
+		m.Unlock()
+		return nil
+	}
+	s.touch(now)
+	if len(s.listeners) == 0 {
+		if len(s.pending) >= m.c.MaxPendingPackets {
+			m.Unlock()
+			return errors.New("handel: too many packets pending for the session")
+		}
+		s.pending = append(s.pending, p)
+		m.Unlock()
+		return nil
+	}
+	listeners := s.listeners
+	m.Unlock()
+	var err error
+	for _, l := range listeners {
+		if e := l.NewPacket(p); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Stop stops the garbage collection of the sessions and the dispatching of
+// the packets.
+func (m *SessionManager) Stop() {
+	m.Lock()
+	defer m.Unlock()
+	if m.stopped {
+		return
+	}
+	m.stopped = true
+	m.sessions = make(map[string]*session)
+	close(m.done)
+}
+
+// run garbage-collects the sessions periodically until the manager is
+// stopped.
+func (m *SessionManager) run() {
+	ticker := time.NewTicker(m.c.Retention / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.collect(now)
+		}
+	}
+}
+
+// collect removes the sessions finished for longer than the retention time,
+// and the sessions created on demand that are still not claimed after the
+// retention time. It finishes the claimed sessions without any packet sent or
+// received during the retention time.
+func (m *SessionManager) collect(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	for sid, s := range m.sessions {
+		switch {
+		case s.finished || !s.claimed:
+			if now.Sub(s.since) >= m.c.Retention {
+				delete(m.sessions, sid)
+			}
+		case s.idle(now) >= m.c.Retention:
+			m.finish(s, now)
+		}
+	}
+}
+
+// pendingSessions returns the number of sessions created on demand that are
+// not claimed yet. This method is NOT thread-safe and only meant for internal
+// use.
+func (m *SessionManager) pendingSessions() int {
+	var count int
+	for _, s := range m.sessions {
+		if !s.claimed && !s.finished {
+			count++
+		}
+	}
+	return count
+}
+
+// evictPending removes the oldest session created on demand that is not
+// claimed yet. This method is NOT thread-safe and only meant for internal use.
+func (m *SessionManager) evictPending() {
+	var oldest *session
+	for _, s := range m.sessions {
+		if s.claimed || s.finished {
+			continue
+		}
+		if oldest == nil || s.since.Before(oldest.since) {
+			oldest = s
+		}
+	}
+	if oldest != nil {
+		delete(m.sessions, string(oldest.id))
+	}
+}
+
+// sessionNetwork is the Network of one session of a SessionManager.
+type sessionNetwork struct {
+	m *SessionManager
+	s *session
+}
+
+// Send stamps the session identifier on the packet and sends it over the
+// Network of the SessionManager. The given packet is not modified. It returns
+// ErrSessionFinished once the session is finished.
+func (n *sessionNetwork) Send(id Identity, p *Packet) error {
+	select {
+	case <-n.s.done:
+		return ErrSessionFinished
+	default:
+	}
+	n.s.touch(time.Now())
+	stamped := *p
+	stamped.Session = n.s.id
+	return n.m.net.Send(id, &stamped)
+}
+
+// RegisterListener registers the listener for the packets of the session. The
+// packets buffered so far are dispatched to it.
+func (n *sessionNetwork) RegisterListener(l Listener) {
+	n.m.Lock()
+	if n.s.finished {
+		n.m.Unlock()
+		return
+	}
+	n.s.listeners = append(n.s.listeners, l)
+	pending := n.s.pending
+	n.s.pending = nil
+	n.m.Unlock()
+	for _, p := range pending {
+		l.NewPacket(p)
+	}
+}
+
+// sessionID returns the given session identifier, or the hash of the message
+// if it is empty.
+func sessionID(sid, msg []byte) []byte {
+	if len(sid) > 0 {
+		return sid
+	}
+	hash := sha256.Sum256(msg)
+	return hash[:]
+}
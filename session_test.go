@@ -0,0 +1,179 @@
+package handel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionManagerRouting(t *testing.T) {
+	net := newTestNetwork()
+	m := NewSessionManager(net.node("fake-0"))
+	defer m.Stop()
+
+	n1, err := m.Network([]byte("s1"))
+	require.NoError(t, err)
+	_, err = m.Network([]byte("s1"))
+	require.Equal(t, ErrSessionExists, err)
+	r1 := new(packetRecorder)
+	n1.RegisterListener(r1)
+
+	// packets of an unknown session are buffered until a listener registers
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s2"), Origin: 1}))
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s1"), Origin: 2}))
+	require.Len(t, r1.received(), 1)
+	require.Equal(t, uint16(2), r1.received()[0].Origin)
+
+	n2, err := m.Network([]byte("s2"))
+	require.NoError(t, err)
+	r2 := new(packetRecorder)
+	n2.RegisterListener(r2)
+	require.Len(t, r2.received(), 1)
+	require.Equal(t, uint16(1), r2.received()[0].Origin)
+
+	// packets of finished sessions are dropped
+	m.Finish([]byte("s1"))
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s1"), Origin: 3}))
+	require.Len(t, r1.received(), 1)
+	_, err = m.Network([]byte("s1"))
+	require.Equal(t, ErrSessionExists, err)
+
+	// sessions finished before being claimed can't be claimed anymore
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s3")}))
+	m.Finish([]byte("s3"))
+	_, err = m.Network([]byte("s3"))
+	require.Equal(t, ErrSessionExists, err)
+}
+
+func TestSessionManagerSend(t *testing.T) {
+	net := newTestNetwork()
+	m := NewSessionManager(net.node("fake-0"))
+	defer m.Stop()
+	r := new(packetRecorder)
+	net.node("fake-1").RegisterListener(r)
+
+	n, err := m.Network([]byte("s1"))
+	require.NoError(t, err)
+	p := &Packet{Origin: 0, Level: 1}
+	require.NoError(t, n.Send(&fakeIdentity{1}, p))
+	require.Eventually(t, func() bool {
+		return len(r.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []byte("s1"), r.received()[0].Session)
+	require.Nil(t, p.Session)
+
+	m.Finish([]byte("s1"))
+	require.Equal(t, ErrSessionFinished, n.Send(&fakeIdentity{1}, p))
+}
+
+func TestSessionManagerLimits(t *testing.T) {
+	m := NewSessionManager(newTestNetwork().node("fake-0"), &SessionConfig{
+		MaxPendingPackets:  2,
+		MaxPendingSessions: 1,
+	})
+	defer m.Stop()
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s1")}))
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s1")}))
+	require.Error(t, m.NewPacket(&Packet{Session: []byte("s1")}))
+	// the oldest pending session makes room for the new one
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("s2")}))
+	require.NotContains(t, m.sessions, "s1")
+	require.Contains(t, m.sessions, "s2")
+}
+
+func TestSessionManagerSchemes(t *testing.T) {
+	m := NewSessionManager(newTestNetwork().node("fake-0"), &SessionConfig{
+		Schemes: []byte{0xfe},
+	})
+	defer m.Stop()
+	require.NoError(t, m.NewPacket(&Packet{Scheme: 0xfe, Session: []byte("s1")}))
+	require.Error(t, m.NewPacket(&Packet{Scheme: 0xff, Session: []byte("s2")}))
+	require.NotContains(t, m.sessions, "s2")
+
+	// the schemes of the Handel instances are accepted as well
+	reg := fakeRegistry(2)
+	id, _ := reg.Identity(0)
+	_, err := m.NewHandel(reg, id, new(fakeScheme), msg, testConfig())
+	require.NoError(t, err)
+	require.NoError(t, m.NewPacket(&Packet{Scheme: 0xff, Session: []byte("s2")}))
+	require.Contains(t, m.sessions, "s2")
+}
+
+func TestSessionManagerCollect(t *testing.T) {
+	m := NewSessionManager(newTestNetwork().node("fake-0"), &SessionConfig{
+		Retention: time.Hour,
+	})
+	defer m.Stop()
+	_, err := m.Network([]byte("running"))
+	require.NoError(t, err)
+	_, err = m.Network([]byte("finished"))
+	require.NoError(t, err)
+	m.Finish([]byte("finished"))
+	require.NoError(t, m.NewPacket(&Packet{Session: []byte("early")}))
+
+	m.collect(time.Now())
+	require.Len(t, m.sessions, 3)
+	m.collect(time.Now().Add(time.Hour))
+	require.Len(t, m.sessions, 1)
+	require.Contains(t, m.sessions, "running")
+	// the running session has been idle for the retention time
+	require.True(t, m.sessions["running"].finished)
+	m.collect(time.Now().Add(2 * time.Hour))
+	require.Len(t, m.sessions, 0)
+}
+
+func TestSessionManagerHandel(t *testing.T) {
+	n := 8
+	reg := fakeRegistry(n)
+	net := newTestNetwork()
+	managers := make([]*SessionManager, n)
+	for i := range managers {
+		id, _ := reg.Identity(i)
+		managers[i] = NewSessionManager(net.node(id.Address()))
+		defer managers[i].Stop()
+	}
+
+	// two overlapping sessions run over the same networks
+	var handels []*Handel
+	for _, msg := range [][]byte{[]byte("block 1"), []byte("block 2")} {
+		for i, m := range managers {
+			id, _ := reg.Identity(i)
+			h, err := m.NewHandel(reg, id, new(fakeScheme), msg, testConfig())
+			require.NoError(t, err)
+			handels = append(handels, h)
+		}
+	}
+	_, err := managers[0].NewHandel(reg, &fakeIdentity{0}, new(fakeScheme), []byte("block 1"), testConfig())
+	require.Equal(t, ErrSessionExists, err)
+
+	for _, h := range handels {
+		h.Start()
+	}
+	for i, h := range handels {
+		require.Eventually(t, func() bool {
+			return h.Aggregate().Cardinality() == n
+		}, 5*time.Second, 10*time.Millisecond, "handel %d", i)
+	}
+	for _, h := range handels {
+		h.Stop()
+	}
+	session := sessionID(nil, []byte("block 1"))
+	require.Eventually(t, func() bool {
+		managers[0].Lock()
+		defer managers[0].Unlock()
+		return managers[0].sessions[string(session)].finished
+	}, time.Second, 10*time.Millisecond)
+
+	// finishing the session stops its Handel instance
+	id, _ := reg.Identity(0)
+	h, err := managers[0].NewHandel(reg, id, new(fakeScheme), []byte("block 3"), testConfig())
+	require.NoError(t, err)
+	h.Start()
+	managers[0].Finish(sessionID(nil, []byte("block 3")))
+	select {
+	case <-h.done:
+	case <-time.After(time.Second):
+		t.Fatal("handel not stopped")
+	}
+}